@@ -2,10 +2,13 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
@@ -13,17 +16,24 @@ import (
 	"github.com/newestuser/eureka-proxy/lib/flags"
 	"github.com/newestuser/eureka-proxy/lib/logging"
 	"github.com/newestuser/eureka-proxy/lib/netutil"
+	"github.com/newestuser/eureka-proxy/lib/reload"
 	"github.com/newestuser/eureka-proxy/lib/reverse-proxy"
+	"github.com/newestuser/eureka-proxy/lib/tlsutil"
 )
 
 const version = "v1.0"
 
 func main() {
 
+	if len(os.Args) > 1 && os.Args[1] == "snapshot" {
+		snapshot(os.Args[2:])
+		return
+	}
+
 	fs := flags.NewFlagSet("eureka-proxy")
 
 	fs.Usage = func() {
-		fmt.Println("\nUsage: eureka-proxy [global flags] <url>")
+		fmt.Println("\nUsage: eureka-proxy [global flags] <url> [<url>...]")
 		fmt.Printf("\nglobal flags:\n")
 		fs.PrintDefaults()
 		fmt.Print(example)
@@ -36,6 +46,15 @@ func main() {
 	traceFlag := fs.BoolFlag("trace", false, "Print all HTTP communication")
 	fakeFlag := fs.StringArrFlag("fake", "", "ServiceID and Port of a dummy application which will be added to the list of registered services \nexample: foo-service:8081")
 	polluteFlag := fs.BoolFlag("pollute", false, "Allow services to register in the real Eureka instance")
+	standaloneFlag := fs.BoolFlag("standalone", false, "Run an in-memory Eureka registry without proxying to a remote Eureka")
+	leaseFlag := fs.IntFlag("lease-duration", 90, "Seconds after which services that stopped sending heartbeats are evicted, 0 disables the eviction")
+	reloadFlag := fs.IntFlag("reload-interval", 2, "Seconds between the checks of the configuration file for changes, 0 disables the checks. SIGHUP always reloads the configuration file")
+	cacheFlag := fs.IntFlag("cache-ttl", 0, "Seconds for which the registry of the remote Eureka is cached, 0 disables the cache")
+	maxStaleFlag := fs.IntFlag("cache-max-stale", 300, "Seconds for which a cached registry is served while it cannot be refreshed because the remote Eureka is unreachable")
+	tlsCertFlag := fs.StringFlag("tls-cert", "", "Certificate file for serving HTTPS")
+	tlsKeyFlag := fs.StringFlag("tls-key", "", "Private key file of the certificate for serving HTTPS")
+	tlsSelfSignedFlag := fs.BoolFlag("tls-self-signed", false, "Serve HTTPS with a self-signed certificate for localhost that is generated on the first run")
+	replayFlag := fs.StringFlag("replay", "", "Serve the registry saved with 'eureka-proxy snapshot' from the file instead of proxying to a remote Eureka")
 
 	args := fs.ParseArgs()
 
@@ -44,67 +63,348 @@ func main() {
 		os.Exit(0)
 	}
 
-	if args.IsEmpty() {
+	// the registry is served without a remote eureka
+	offline := standaloneFlag.Get() || replayFlag.IsSet()
+
+	if args.IsEmpty() && !offline {
 		fmt.Println("Specify eureka url or valid config file")
 		fs.Usage()
 		os.Exit(1)
 	}
 
-	arg := args.First()
-
-	var routes []*reverse.RouteConfig = nil
-	var eurekaUrl *url.URL = nil
-	var fakes = make([]*fake.Application, 0)
+	config := &eurekaConfig{fakes: make([]*fake.Application, 0), tls: &tlsutil.ServerConfig{}}
+	configFile := ""
 
-	if isUrl, urlArg := arg.IsURL(); isUrl {
-		routes = reverse.SingleRoute("/", stripFlag.Get(), urlArg)
-		eurekaUrl = urlArg
-	} else if isFile, bytes := arg.IsFile(); isFile {
-		routes, eurekaUrl, fakes = parseYmlFile(bytes)
+	if offline {
+		if !args.IsEmpty() {
+			config = parseOfflineConfig(args.First())
+			configFile = args.First().Val()
+		}
 	} else {
-		log.Fatal(fmt.Sprintf("Please provide a valid eureka URL like http://ziongw1-dev.neterra.skrill.net:8761 or a configuration file"))
+		config, configFile = parseUpstreamConfig(args, stripFlag.Get())
 	}
 
-	c := &reverse.ProxyConfig{
-		Routes:     routes,
-		Port:       portFlag.Get(),
-		LoggingOff: true,
+	withFlags := func(config *eurekaConfig) {
+		for _, serviceAndPort := range fakeFlag.Values() {
+			config.fakes = append(config.fakes, fakeApp(serviceAndPort))
+		}
+
+		if leaseFlag.IsSet() || config.leaseDuration == nil {
+			leaseDuration := time.Duration(leaseFlag.Get()) * time.Second
+			config.leaseDuration = &leaseDuration
+		}
 	}
 
-	proxy, err := reverse.NewReverseProxy(c)
+	withFlags(config)
 
-	if err != nil {
-		log.Fatal(fmt.Sprintf("Unable to initialize proxy, err:%s\n", err.Error()))
+	// the flags take precedence over the configuration file, the TLS settings are not reloaded
+	if tlsCertFlag.IsSet() {
+		config.tls.CertFile = tlsCertFlag.Get()
 	}
 
-	if fakeFlag.IsSet() {
-		for _, serviceAndPort := range fakeFlag.Values() {
-			fakes = append(fakes, fakeApp(serviceAndPort))
+	if tlsKeyFlag.IsSet() {
+		config.tls.KeyFile = tlsKeyFlag.Get()
+	}
+
+	if tlsSelfSignedFlag.IsSet() {
+		config.tls.SelfSigned = tlsSelfSignedFlag.Get()
+	}
+
+	reloadInterval := time.Duration(reloadFlag.Get()) * time.Second
+
+	if offline {
+		var registry fake.Registry
+		var mode string
+
+		if replayFlag.IsSet() {
+			registry = fake.RequestHandler(config.fakes, false, *config.leaseDuration, mustReadSnapshot(replayFlag.Get()))
+			mode = fmt.Sprintf("Replay of %s", replayFlag.Get())
+		} else {
+			registry = fake.StandaloneHandler(config.fakes, *config.leaseDuration)
+			mode = "Standalone eureka"
+		}
+
+		if configFile != "" {
+			reload.Watch(configFile, reloadInterval, func(bytes []byte) error {
+				newConfig, err := parseYmlFile(bytes, false)
+				if err != nil {
+					return err
+				}
+
+				withFlags(newConfig)
+				registry.Reload(newConfig.fakes, *newConfig.leaseDuration)
+
+				return nil
+			})
 		}
+
+		startOffline(mode, portFlag.Get(), config, registry, traceFlag.Get())
+		return
+	}
+
+	port := portFlag.Get()
+	upstream := mustMergeEnvironments(config)
+
+	var chain http.Handler = upstream
+	var cache *fake.Cache
+
+	if cacheFlag.Get() > 0 {
+		cache = fake.CachingHandler(upstream, time.Duration(cacheFlag.Get())*time.Second, time.Duration(maxStaleFlag.Get())*time.Second)
+		chain = cache
 	}
 
-	handler := fake.RequestHandler(fakes, polluteFlag.Get(), proxy)
-	handler = loggingHandler(handler, traceFlag.Get())
+	registry := fake.RequestHandler(config.fakes, polluteFlag.Get(), *config.leaseDuration, chain)
+	handler := loggingHandler(registry, traceFlag.Get())
 
-	log.Printf("Reverse proxy starting on port %d\n", c.Port)
-	log.Printf("Proxying to %s\n", eurekaUrl.String())
+	if configFile != "" {
+		reload.Watch(configFile, reloadInterval, func(bytes []byte) error {
+			newConfig, err := parseYmlFile(bytes, true)
+			if err != nil {
+				return err
+			}
+
+			newEnvs, err := newEnvironments(newConfig)
+			if err != nil {
+				return err
+			}
+
+			if err := upstream.Reload(newEnvs, newConfig.precedence); err != nil {
+				return err
+			}
+
+			if cache != nil {
+				cache.Clear()
+			}
+
+			withFlags(newConfig)
+			registry.Reload(newConfig.fakes, *newConfig.leaseDuration)
+
+			return nil
+		})
+	}
 
-	for _, fakeApp := range fakes {
+	log.Printf("Reverse proxy starting on %s://localhost:%d\n", config.tls.Scheme(), port)
+	for _, env := range config.environments {
+		for _, eurekaUrl := range env.eurekaUrls {
+			if env.name == "" {
+				log.Printf("Proxying to %s\n", eurekaUrl.String())
+			} else {
+				log.Printf("Proxying %s to %s\n", env.name, eurekaUrl.String())
+			}
+		}
+	}
+
+	for appID, preferred := range config.precedence {
+		log.Printf("Taking %s from %s\n", appID, strings.Join(preferred, ", "))
+	}
+
+	if cache != nil {
+		log.Printf("Caching the registry for %ds\n", cacheFlag.Get())
+	}
+
+	log.Printf("Fakes can be managed on %s and inspected on %s\n", fake.AdminPath, fake.DashboardPath)
+
+	for _, fakeApp := range config.fakes {
 		log.Printf("Injecting %s\n\n", fakeApp)
 	}
 
-	if err = http.ListenAndServe(fmt.Sprintf(":%d", c.Port), handler); err != nil {
+	if err := tlsutil.ListenAndServe(fmt.Sprintf(":%d", port), handler, config.tls); err != nil {
 		log.Fatal(fmt.Sprintf("Unable to start proxy, err:%s", err.Error()))
 	}
 }
 
+// Start an in-memory eureka registry that contains the fakes and the services that register in it,
+// the registry is either empty or replayed from a snapshot.
+func startOffline(mode string, port int, config *eurekaConfig, registry fake.Registry, trace bool) {
+
+	handler := loggingHandler(registry, trace)
+
+	log.Printf("%s starting on %s://localhost:%d\n", mode, config.tls.Scheme(), port)
+	log.Printf("Fakes can be managed on %s and inspected on %s\n", fake.AdminPath, fake.DashboardPath)
+
+	for _, fakeApp := range config.fakes {
+		log.Printf("Injecting %s\n\n", fakeApp)
+	}
+
+	if err := tlsutil.ListenAndServe(fmt.Sprintf(":%d", port), handler, config.tls); err != nil {
+		log.Fatal(fmt.Sprintf("Unable to start %s, err:%s", strings.ToLower(mode), err.Error()))
+	}
+}
+
+// In standalone and replay mode only the fakes are used from the configuration file, the eurekaUrl is optional.
+func parseOfflineConfig(arg *flags.Arg) *eurekaConfig {
+
+	isFile, bytes := arg.IsFile()
+
+	if !isFile {
+		log.Fatal(fmt.Sprintf("Please provide a valid configuration file or no arguments when running standalone or replaying a snapshot"))
+	}
+
+	return mustParseYmlFile(bytes, false)
+}
+
+// Resolve the remote eureka from the urls or the configuration file in the arguments,
+// the path of the configuration file is empty when urls are used.
+func parseUpstreamConfig(args *flags.CommandArgs, strip string) (*eurekaConfig, string) {
+
+	if isUrl, _ := args.First().IsURL(); isUrl {
+		urls := parseUrlArgs(args)
+		env := &environmentConfig{routes: reverse.FailoverRoute("/", strip, urls), eurekaUrls: urls}
+
+		return &eurekaConfig{environments: []*environmentConfig{env}, fakes: make([]*fake.Application, 0), tls: &tlsutil.ServerConfig{}}, ""
+	}
+
+	if isFile, bytes := args.First().IsFile(); isFile {
+		return mustParseYmlFile(bytes, true), args.First().Val()
+	}
+
+	log.Fatal(fmt.Sprintf("Please provide a valid eureka URL like http://ziongw1-dev.neterra.skrill.net:8761 or a configuration file"))
+	return nil, ""
+}
+
+// Save the registry of the remote eureka to a file that can be served later with the -replay flag.
+func snapshot(cmdArgs []string) {
+
+	fs := flags.NewFlagSet("eureka-proxy snapshot")
+
+	fs.Usage = func() {
+		fmt.Println("\nUsage: eureka-proxy snapshot [flags] <url> [<url>...]")
+		fmt.Printf("\nflags:\n")
+		fs.PrintDefaults()
+		fmt.Print(snapshotExample)
+		return
+	}
+
+	outFlag := fs.StringFlag("o", "eureka-snapshot.json", "File in which the registry is saved")
+
+	args := fs.ParseCommandArgs(cmdArgs)
+
+	if args.IsEmpty() {
+		fmt.Println("Specify eureka url or valid config file")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	config, _ := parseUpstreamConfig(args, "")
+
+	registry, err := fake.Snapshot(mustMergeEnvironments(config))
+
+	if err != nil {
+		log.Fatal(fmt.Sprintf("Unable to fetch the registry, err:%s", err.Error()))
+	}
+
+	if err := ioutil.WriteFile(outFlag.Get(), registry, 0644); err != nil {
+		log.Fatal(fmt.Sprintf("Unable to save the registry, err:%s", err.Error()))
+	}
+
+	log.Printf("The registry was saved to %s, serve it with: eureka-proxy -replay %s\n", outFlag.Get(), outFlag.Get())
+}
+
+func mustReadSnapshot(path string) http.Handler {
+
+	bytes, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		log.Fatal(fmt.Sprintf("Unable to read the snapshot, err:%s", err.Error()))
+	}
+
+	upstream, err := fake.ReplayHandler(bytes)
+
+	if err != nil {
+		log.Fatal(fmt.Sprintf("Unable to replay the snapshot, err:%s", err.Error()))
+	}
+
+	return upstream
+}
+
+func mustMergeEnvironments(config *eurekaConfig) *fake.Environments {
+
+	environments, err := newEnvironments(config)
+
+	if err != nil {
+		log.Fatal(fmt.Sprintf("Unable to initialize proxy, err:%s\n", err.Error()))
+	}
+
+	upstream, err := fake.MergeEnvironments(environments, config.precedence)
+
+	if err != nil {
+		log.Fatal(fmt.Sprintf("Unable to initialize proxy, err:%s\n", err.Error()))
+	}
+
+	return upstream
+}
+
+// Every argument is an url of a peer eureka, the next one is used when the previous cannot be reached.
+func parseUrlArgs(args *flags.CommandArgs) []*url.URL {
+	urls := make([]*url.URL, 0)
+
+	for _, arg := range args.All() {
+		isUrl, urlArg := arg.IsURL()
+
+		if !isUrl {
+			log.Fatal(fmt.Sprintf("Please provide only valid eureka URLs, invalid: %s", arg.Val()))
+		}
+
+		urls = append(urls, urlArg)
+	}
+
+	return urls
+}
+
 func fakeApp(serviceAndPort string) *fake.Application {
 	serviceID, port := flags.ParseIdAndPort(serviceAndPort)
 
 	return fake.SingleLocalApp(serviceID, port)
 }
 
-func parseYmlFile(bytes []byte) ([]*reverse.RouteConfig, *url.URL, []*fake.Application) {
+// Create a reverse proxy for every environment, the registries of the environments are merged by the registry.
+func newEnvironments(config *eurekaConfig) ([]*fake.Environment, error) {
+	environments := make([]*fake.Environment, 0, len(config.environments))
+
+	for _, env := range config.environments {
+		proxy, err := reverse.NewReverseProxy(&reverse.ProxyConfig{Routes: env.routes, LoggingOff: true})
+
+		if err != nil {
+			return nil, err
+		}
+
+		environments = append(environments, &fake.Environment{Name: env.name, Handler: proxy})
+	}
+
+	return environments, nil
+}
+
+// The configuration of the proxy resolved from the program arguments or the configuration file.
+type eurekaConfig struct {
+	environments []*environmentConfig
+	// the environments the applications are taken from in order of preference, keyed by the application id
+	precedence map[string][]string
+	fakes      []*fake.Application
+	// the TLS settings of the listener
+	tls *tlsutil.ServerConfig
+
+	// nil when the lease duration is not configured
+	leaseDuration *time.Duration
+}
+
+// A remote eureka with its peers, the name is empty when a single environment is configured.
+type environmentConfig struct {
+	name       string
+	routes     []*reverse.RouteConfig
+	eurekaUrls []*url.URL
+}
+
+func mustParseYmlFile(bytes []byte, requireEurekaUrl bool) *eurekaConfig {
+	config, err := parseYmlFile(bytes, requireEurekaUrl)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return config
+}
+
+func parseYmlFile(bytes []byte, requireEurekaUrl bool) (*eurekaConfig, error) {
 
 	type fakeAppConfig struct {
 		Id       string `yaml:"id"`
@@ -112,34 +412,102 @@ func parseYmlFile(bytes []byte) ([]*reverse.RouteConfig, *url.URL, []*fake.Appli
 		HostName string `yaml:"hostname"`
 	}
 
+	type upstreamTlsConfig struct {
+		CA                 string `yaml:"ca"`
+		Cert               string `yaml:"cert"`
+		Key                string `yaml:"key"`
+		ServerName         string `yaml:"serverName"`
+		InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+	}
+
+	type envConfig struct {
+		Name       string             `yaml:"name"`
+		EurekaUrl  string             `yaml:"eurekaUrl"`
+		EurekaUrls []string           `yaml:"eurekaUrls"`
+		Tls        *upstreamTlsConfig `yaml:"tls"`
+	}
+
+	type tlsConfig struct {
+		Cert       string `yaml:"cert"`
+		Key        string `yaml:"key"`
+		SelfSigned bool   `yaml:"selfSigned"`
+	}
+
 	type routeConfig struct {
 		Proxy struct {
-			EurekaUrl string           `yaml:"eurekaUrl"`
-			Port      string           `yaml:"port"`
-			Fakes     []*fakeAppConfig `yaml:"fakes"`
+			EurekaUrl     string              `yaml:"eurekaUrl"`
+			EurekaUrls    []string            `yaml:"eurekaUrls"`
+			UpstreamTls   *upstreamTlsConfig  `yaml:"upstreamTls"`
+			Environments  []*envConfig        `yaml:"environments"`
+			Precedence    map[string][]string `yaml:"precedence"`
+			Port          string              `yaml:"port"`
+			LeaseDuration *int                `yaml:"leaseDuration"`
+			Fakes         []*fakeAppConfig    `yaml:"fakes"`
+			Tls           tlsConfig           `yaml:"tls"`
 		}
 	}
 
 	config := &routeConfig{}
 	if err := yaml.Unmarshal(bytes, config); err != nil {
-		log.Fatalf("could not parse yaml file err: %s\n", err.Error())
+		return nil, fmt.Errorf("could not parse yaml file err: %s", err.Error())
 	}
 
-	if config.Proxy.EurekaUrl == "" {
-		log.Fatalf("please specify a valid eurekaUrl in the yml configuration\n")
+	upstreamTls := func(tls *upstreamTlsConfig) *tlsutil.ClientConfig {
+		if tls == nil {
+			return nil
+		}
+
+		return &tlsutil.ClientConfig{CAFile: tls.CA, CertFile: tls.Cert, KeyFile: tls.Key, ServerName: tls.ServerName, InsecureSkipVerify: tls.InsecureSkipVerify}
 	}
 
-	targetUrl, err := url.Parse(config.Proxy.EurekaUrl)
-	if err != nil {
-		log.Fatalf("please provide a valid eurekaUrl err:%s\n", err.Error())
+	environments := make([]*environmentConfig, 0)
+
+	if config.Proxy.EurekaUrl != "" || len(config.Proxy.EurekaUrls) > 0 {
+		if len(config.Proxy.Environments) > 0 {
+			return nil, fmt.Errorf("please specify either the eurekaUrl or the environments in the yml configuration")
+		}
+
+		env, err := parseEnvironment("", config.Proxy.EurekaUrl, config.Proxy.EurekaUrls, upstreamTls(config.Proxy.UpstreamTls))
+		if err != nil {
+			return nil, err
+		}
+
+		environments = append(environments, env)
+	}
+
+	for _, envConf := range config.Proxy.Environments {
+		if envConf.Name == "" {
+			return nil, fmt.Errorf("please specify the name of every environment in the yml configuration")
+		}
+
+		env, err := parseEnvironment(envConf.Name, envConf.EurekaUrl, envConf.EurekaUrls, upstreamTls(envConf.Tls))
+		if err != nil {
+			return nil, err
+		}
+
+		environments = append(environments, env)
+	}
+
+	if len(environments) == 0 && requireEurekaUrl {
+		return nil, fmt.Errorf("please specify a valid eurekaUrl, eurekaUrls or environments in the yml configuration")
+	}
+
+	if config.Proxy.UpstreamTls != nil && len(config.Proxy.Environments) > 0 {
+		return nil, fmt.Errorf("please specify the tls of every environment instead of the upstreamTls in the yml configuration")
+	}
+
+	if len(config.Proxy.Precedence) > 0 && len(config.Proxy.Environments) == 0 {
+		return nil, fmt.Errorf("the precedence requires the environments in the yml configuration")
 	}
 
-	routes := reverse.SingleRoute("/", "", targetUrl)
 	fakes := make([]*fake.Application, 0)
 
 	for _, fakeConfig := range config.Proxy.Fakes {
 
-		serviceId, port := flags.ParseIdAndPort(fakeConfig.Id)
+		serviceId, port, err := flags.SplitIdAndPort(fakeConfig.Id)
+		if err != nil {
+			return nil, err
+		}
 		ip := valOrDefault(fakeConfig.Ip, netutil.OutboundIP().String)
 		host := valOrDefault(fakeConfig.HostName, defaultHost)
 
@@ -147,7 +515,45 @@ func parseYmlFile(bytes []byte) ([]*reverse.RouteConfig, *url.URL, []*fake.Appli
 		fakes = append(fakes, fakeApp)
 	}
 
-	return routes, targetUrl, fakes
+	tls := &tlsutil.ServerConfig{CertFile: config.Proxy.Tls.Cert, KeyFile: config.Proxy.Tls.Key, SelfSigned: config.Proxy.Tls.SelfSigned}
+	eurekaConf := &eurekaConfig{environments: environments, precedence: config.Proxy.Precedence, fakes: fakes, tls: tls}
+
+	if config.Proxy.LeaseDuration != nil {
+		leaseDuration := time.Duration(*config.Proxy.LeaseDuration) * time.Second
+		eurekaConf.leaseDuration = &leaseDuration
+	}
+
+	return eurekaConf, nil
+}
+
+// Parse the urls of an environment, the peers are tried in order starting with the eurekaUrl.
+// The TLS settings are used to connect to all the peers, nil uses the defaults of the system.
+func parseEnvironment(name, eurekaUrl string, eurekaUrls []string, tls *tlsutil.ClientConfig) (*environmentConfig, error) {
+
+	rawUrls := eurekaUrls
+	if eurekaUrl != "" {
+		rawUrls = append([]string{eurekaUrl}, rawUrls...)
+	}
+
+	if len(rawUrls) == 0 {
+		return nil, fmt.Errorf("please specify a valid eurekaUrl or eurekaUrls for the environment %s", name)
+	}
+
+	targetUrls := make([]*url.URL, 0, len(rawUrls))
+
+	for _, rawUrl := range rawUrls {
+		targetUrl, err := url.Parse(rawUrl)
+		if err != nil {
+			return nil, fmt.Errorf("please provide a valid eurekaUrl err:%s", err.Error())
+		}
+
+		targetUrls = append(targetUrls, targetUrl)
+	}
+
+	routes := reverse.FailoverRoute("/", "", targetUrls)
+	routes[0].TLS = tls
+
+	return &environmentConfig{name: name, routes: routes, eurekaUrls: targetUrls}, nil
 }
 
 func defaultHost() string {
@@ -173,4 +579,14 @@ func loggingHandler(chain http.Handler, traceOn bool) http.Handler {
 const example = `
 example:
         eureka-proxy http://my-dev-environment.net:8761
+        eureka-proxy http://eureka1.my-dev-environment.net:8761 http://eureka2.my-dev-environment.net:8761
+        eureka-proxy -standalone
+        eureka-proxy snapshot -o registry.json http://my-dev-environment.net:8761
+        eureka-proxy -replay registry.json
+`
+
+const snapshotExample = `
+example:
+        eureka-proxy snapshot http://my-dev-environment.net:8761
+        eureka-proxy snapshot -o registry.json ./path/to/config.yml
 `
@@ -3,11 +3,16 @@ package main
 import (
 	"fmt"
 	"github.com/newestuser/eureka-proxy/lib/flags"
+	"github.com/newestuser/eureka-proxy/lib/reload"
 	"github.com/newestuser/eureka-proxy/lib/reverse-proxy"
+	"github.com/newestuser/eureka-proxy/lib/reverse-proxy/header"
+	"github.com/newestuser/eureka-proxy/lib/tlsutil"
 	"gopkg.in/yaml.v2"
 	"log"
 	"net/url"
 	"os"
+	"sort"
+	"time"
 )
 
 const version = "v1.3"
@@ -19,27 +24,31 @@ func main() {
 	versionFlag := fs.BoolFlag("v", false, "proxy version")
 	portFlag := fs.IntFlag("port", 4400, "proxy port")
 	stripFlag := fs.StringFlag("strip", "", "strip or replace part of url")
+	rewriteFlag := fs.StringArrFlag("rewrite", "", "rewrite the path with a prefix or regex rule, the rules are tried in order and the first matching rule rewrites the path \nexample: prefix:/api/=>/ or regex:^/api/v(\\d+)/(.*)=>/v$1/$2")
+	rewriteHostFlag := fs.BoolFlag("rewrite-host", false, "send the host of the url in the Host header instead of the host the client requested")
+	balancingFlag := fs.StringFlag("balancing", reverse.RoundRobin, "the strategy that spreads the requests across multiple urls: round-robin, random, least-connections or weighted")
 	traceFlag := fs.BoolFlag("trace", false, "trace proxied requests")
 	enableCorsFlag := fs.BoolFlag("enable-cors", false, "enable CORS requests")
-
+	reloadFlag := fs.IntFlag("reload-interval", 2, "seconds between the checks of the configuration file for changes, 0 disables the checks. SIGHUP always reloads the configuration file")
+	tlsCertFlag := fs.StringFlag("tls-cert", "", "certificate file for serving HTTPS")
+	tlsKeyFlag := fs.StringFlag("tls-key", "", "private key file of the certificate for serving HTTPS")
+	tlsSelfSignedFlag := fs.BoolFlag("tls-self-signed", false, "serve HTTPS with a self-signed certificate for localhost that is generated on the first run")
 
 	fs.Usage = func() {
-		fmt.Println("\nUsage: reverse-proxy [global flags] <url>")
+		fmt.Println("\nUsage: reverse-proxy [global flags] <url> [<url>...]")
 		fmt.Printf("\nglobal flags:\n")
 		fs.PrintDefaults()
 		fmt.Print(example)
 		return
 	}
 
-	fs.ParseArgs()
+	args := fs.ParseArgs()
 
 	if versionFlag.IsSet() {
 		fmt.Println(version)
 		os.Exit(0)
 	}
 
-	args := fs.ParseArgs()
-
 	if args.IsEmpty() {
 		fmt.Println("Specify url to proxy against or valid config file")
 		fs.Usage()
@@ -49,24 +58,55 @@ func main() {
 	urlOrFile := args.First()
 
 	var routes []*reverse.RouteConfig = nil
+	tlsConf := &tlsutil.ServerConfig{}
+	configFile := ""
 
 	if isFile, bytes := urlOrFile.IsFile(); isFile {
 
-		parsedConfig := readRouteConfiguration(bytes)
-		routes = adaptRouteConfiguration(parsedConfig)
+		var err error
+		if routes, err = parseRoutes(bytes); err != nil {
+			log.Fatal(err)
+		}
+
+		if tlsConf, err = parseTls(bytes); err != nil {
+			log.Fatal(err)
+		}
+
+		configFile = urlOrFile.Val()
 
 	} else if isUrl, targetUrl := urlOrFile.IsURL(); isUrl {
 		routes = reverse.SingleRoute("/", stripFlag.Get(), targetUrl)
 
+		if !args.HasSize(1) {
+			routes = reverse.BalancedRoute("/", stripFlag.Get(), balancingFlag.Get(), parseUrlArgs(args))
+		}
+
+		routes[0].PathRewrites = rewriteFlag.Values()
+		routes[0].RewriteHost = rewriteHostFlag.Get()
+
 	} else {
 		log.Fatal(fmt.Sprintf("Please provide a valid URL or YAML configuration as an argument."))
 	}
 
+	// the flags take precedence over the configuration file
+	if tlsCertFlag.IsSet() {
+		tlsConf.CertFile = tlsCertFlag.Get()
+	}
+
+	if tlsKeyFlag.IsSet() {
+		tlsConf.KeyFile = tlsKeyFlag.Get()
+	}
+
+	if tlsSelfSignedFlag.IsSet() {
+		tlsConf.SelfSigned = tlsSelfSignedFlag.Get()
+	}
+
 	c := &reverse.ProxyConfig{
-		Routes: routes,
-		Port:   portFlag.Get(),
-		Trace:  traceFlag.Get(),
+		Routes:     routes,
+		Port:       portFlag.Get(),
+		Trace:      traceFlag.Get(),
 		EnableCORS: enableCorsFlag.Get(),
+		TLS:        tlsConf,
 	}
 
 	proxy, err := reverse.NewReverseProxy(c)
@@ -74,6 +114,17 @@ func main() {
 		log.Fatal(fmt.Sprintf("Unable to start proxy, err:%s", err.Error()))
 	}
 
+	if configFile != "" {
+		reload.Watch(configFile, time.Duration(reloadFlag.Get())*time.Second, func(bytes []byte) error {
+			routes, err := parseRoutes(bytes)
+			if err != nil {
+				return err
+			}
+
+			return proxy.Reload(routes)
+		})
+	}
+
 	if err = proxy.Start(); err != nil {
 		log.Fatal(fmt.Sprintf("Unable to start proxy, err:%s", err.Error()))
 	}
@@ -82,31 +133,106 @@ func main() {
 type routeConfig struct {
 	Proxy struct {
 		Routes map[string]struct {
-			Path        string `yaml:"path"`
-			Url         string `yaml:"url"`
-			StripPrefix bool   `yaml:"stripPrefix"`
+			Path        string             `yaml:"path"`
+			Url         string             `yaml:"url"`
+			StripPrefix bool               `yaml:"stripPrefix"`
+			Rewrite     []string           `yaml:"rewrite"`
+			Tls         *upstreamTlsConfig `yaml:"tls"`
+			RewriteHost bool               `yaml:"rewriteHost"`
+			Priority    int                `yaml:"priority"`
+			Balancing   string             `yaml:"balancing"`
+			Targets     []struct {
+				Url    string `yaml:"url"`
+				Weight int    `yaml:"weight"`
+			}
+			Match struct {
+				Host    string            `yaml:"host"`
+				Methods []string          `yaml:"methods"`
+				Headers map[string]string `yaml:"headers"`
+				Queries map[string]string `yaml:"queries"`
+			}
+			Headers struct {
+				Request  []*headerRuleConfig `yaml:"request"`
+				Response []*headerRuleConfig `yaml:"response"`
+			}
+		}
+		Tls struct {
+			Cert       string `yaml:"cert"`
+			Key        string `yaml:"key"`
+			SelfSigned bool   `yaml:"selfSigned"`
 		}
 	}
 }
 
-func readRouteConfiguration(fileBytes []byte) *routeConfig {
+// The TLS settings used to connect to the url of a route.
+type upstreamTlsConfig struct {
+	CA                 string `yaml:"ca"`
+	Cert               string `yaml:"cert"`
+	Key                string `yaml:"key"`
+	ServerName         string `yaml:"serverName"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+// A header rule, exactly one of the actions is set to the name of the header.
+type headerRuleConfig struct {
+	Add    string `yaml:"add"`
+	Set    string `yaml:"set"`
+	Remove string `yaml:"remove"`
+	Rename string `yaml:"rename"`
+	Value  string `yaml:"value"`
+	To     string `yaml:"to"`
+}
+
+// Parse the TLS settings of the listener from the yaml configuration, they are not reloaded.
+func parseTls(fileBytes []byte) (*tlsutil.ServerConfig, error) {
+
+	parsedConfig, err := readRouteConfiguration(fileBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	tls := parsedConfig.Proxy.Tls
+
+	return &tlsutil.ServerConfig{CertFile: tls.Cert, KeyFile: tls.Key, SelfSigned: tls.SelfSigned}, nil
+}
+
+// Parse the routes from the yaml configuration.
+func parseRoutes(fileBytes []byte) ([]*reverse.RouteConfig, error) {
+
+	parsedConfig, err := readRouteConfiguration(fileBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return adaptRouteConfiguration(parsedConfig)
+}
+
+func readRouteConfiguration(fileBytes []byte) (*routeConfig, error) {
 
 	config := &routeConfig{}
 	if err := yaml.Unmarshal(fileBytes, config); err != nil {
-		log.Fatalf("could not parse yaml file err: %s\n", err.Error())
+		return nil, fmt.Errorf("could not parse yaml file err: %s", err.Error())
 	}
 
-	return config
+	return config, nil
 }
 
-func adaptRouteConfiguration(routeConfig *routeConfig) []*reverse.RouteConfig {
+func adaptRouteConfiguration(routeConfig *routeConfig) ([]*reverse.RouteConfig, error) {
 	routes := make([]*reverse.RouteConfig, 0)
 
-	for routeLabel, route := range routeConfig.Proxy.Routes {
+	// the labels are sorted so that the routes that are otherwise equal are matched in the same order on every run
+	labels := make([]string, 0, len(routeConfig.Proxy.Routes))
+	for routeLabel := range routeConfig.Proxy.Routes {
+		labels = append(labels, routeLabel)
+	}
+	sort.Strings(labels)
+
+	for _, routeLabel := range labels {
+		route := routeConfig.Proxy.Routes[routeLabel]
 		routeURL, routeErr := url.Parse(route.Url)
 
 		if routeErr != nil {
-			log.Fatalf("the url %s for route %s is invalid, err:%s", route.Url, routeLabel, routeErr.Error())
+			return nil, fmt.Errorf("the url %s for route %s is invalid, err:%s", route.Url, routeLabel, routeErr.Error())
 		}
 
 		strip := ""
@@ -114,13 +240,110 @@ func adaptRouteConfiguration(routeConfig *routeConfig) []*reverse.RouteConfig {
 			strip = fmt.Sprintf("%s:%s", route.Path, "")
 		}
 
-		routes = append(routes, reverse.SingleRoute(route.Path, strip, routeURL)...)
+		path := route.Path
+		if path == "" {
+			path = "/"
+		}
+
+		routeConf := reverse.NewRouteConfig(path, strip, routeURL)
+		routeConf.Host = route.Match.Host
+		routeConf.Methods = route.Match.Methods
+		routeConf.Headers = route.Match.Headers
+		routeConf.Queries = route.Match.Queries
+		routeConf.PathRewrites = route.Rewrite
+		routeConf.RewriteHost = route.RewriteHost
+		routeConf.Priority = route.Priority
+
+		for _, target := range route.Targets {
+			targetURL, targetErr := url.Parse(target.Url)
+
+			if targetErr != nil {
+				return nil, fmt.Errorf("the target url %s for route %s is invalid, err:%s", target.Url, routeLabel, targetErr.Error())
+			}
+
+			routeConf.Targets = append(routeConf.Targets, &reverse.Target{URL: targetURL, Weight: target.Weight})
+		}
+
+		if len(routeConf.Targets) > 0 {
+			if route.Url != "" {
+				return nil, fmt.Errorf("the route %s has both a url and targets, please specify only one of them", routeLabel)
+			}
+
+			routeConf.TargetURL = routeConf.Targets[0].URL
+			routeConf.Balancing = route.Balancing
+		}
+
+		var headerErr error
+
+		if routeConf.RequestHeaders, headerErr = adaptHeaderRules(route.Headers.Request); headerErr != nil {
+			return nil, fmt.Errorf("the request headers of route %s are invalid, err:%s", routeLabel, headerErr.Error())
+		}
+
+		if routeConf.ResponseHeaders, headerErr = adaptHeaderRules(route.Headers.Response); headerErr != nil {
+			return nil, fmt.Errorf("the response headers of route %s are invalid, err:%s", routeLabel, headerErr.Error())
+		}
+
+		if tls := route.Tls; tls != nil {
+			routeConf.TLS = &tlsutil.ClientConfig{CAFile: tls.CA, CertFile: tls.Cert, KeyFile: tls.Key, ServerName: tls.ServerName, InsecureSkipVerify: tls.InsecureSkipVerify}
+		}
+
+		routes = append(routes, routeConf)
+	}
+
+	return routes, nil
+}
+
+// Parse the urls the requests are spread across, every url is a target with the same weight.
+func parseUrlArgs(args *flags.CommandArgs) []*reverse.Target {
+	targets := make([]*reverse.Target, 0)
+
+	for _, arg := range args.All() {
+		isUrl, urlArg := arg.IsURL()
+
+		if !isUrl {
+			log.Fatal(fmt.Sprintf("Please provide only valid URLs, invalid: %s", arg.Val()))
+		}
+
+		targets = append(targets, &reverse.Target{URL: urlArg, Weight: 1})
+	}
+
+	return targets
+}
+
+func adaptHeaderRules(ruleConfigs []*headerRuleConfig) ([]*header.Rule, error) {
+	rules := make([]*header.Rule, 0, len(ruleConfigs))
+
+	for _, c := range ruleConfigs {
+		var rule *header.Rule
+		var err error
+
+		switch {
+		case c.Add != "":
+			rule, err = header.NewRule(header.Add, c.Add, c.Value)
+		case c.Set != "":
+			rule, err = header.NewRule(header.Set, c.Set, c.Value)
+		case c.Remove != "":
+			rule, err = header.NewRule(header.Remove, c.Remove, "")
+		case c.Rename != "":
+			rule, err = header.NewRule(header.Rename, c.Rename, c.To)
+		default:
+			err = fmt.Errorf("specify the header to add, set, remove or rename")
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, rule)
 	}
 
-	return routes
+	return rules, nil
 }
 
 const example = `
 example:
         reverse-proxy http://ziongw1-dev.neterra.skrill.net:8888
-`
\ No newline at end of file
+        reverse-proxy -tls-self-signed http://localhost:4200
+        reverse-proxy -balancing least-connections http://localhost:8080 http://localhost:8081
+        reverse-proxy -rewrite 'regex:^/api/v(\d+)/(.*)=>/v$1/$2' http://localhost:8080
+`
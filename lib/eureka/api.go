@@ -1,17 +1,29 @@
 package eureka
 
 import (
+	"bytes"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"sort"
 	"strings"
 )
 
 type Status string
 
 const (
-	UP      = "UP"
-	DOWN    = "DOWN"
-	UNKNOWN = "UNKNOWN"
+	UP             = "UP"
+	DOWN           = "DOWN"
+	STARTING       = "STARTING"
+	OUT_OF_SERVICE = "OUT_OF_SERVICE"
+	UNKNOWN        = "UNKNOWN"
+)
+
+// The action types used in a delta of the registry.
+const (
+	ADDED    = "ADDED"
+	MODIFIED = "MODIFIED"
+	DELETED  = "DELETED"
 )
 
 type State struct {
@@ -21,7 +33,7 @@ type State struct {
 type Applications struct {
 	XMLName      xml.Name       `json:"-" xml:"applications"`
 	Version      interface{}    `json:"versions__delta" xml:"versions__delta"`
-	Status       string         `json:"apps__hashcode" xml:"apps__hashcode"`
+	HashCode     string         `json:"apps__hashcode" xml:"apps__hashcode"`
 	Applications []*Application `json:"application" xml:"application"`
 }
 
@@ -40,6 +52,44 @@ func (a *Applications) AddApp(app ...*Application) {
 	a.Applications = append(a.Applications, app...)
 }
 
+// Remove all applications matching the provided ID.
+func (a *Applications) RemoveApp(ID string) {
+	apps := make([]*Application, 0, len(a.Applications))
+
+	for _, app := range a.Applications {
+		if !strings.EqualFold(ID, app.Name) {
+			apps = append(apps, app)
+		}
+	}
+
+	a.Applications = apps
+}
+
+// Compute the hash code that eureka clients use to reconcile their local copy of the registry
+// after applying a delta. The format is the count of instances per status ordered by status, example: DOWN_1_UP_3_
+func (a *Applications) ReconcileHashCode() string {
+	counts := make(map[string]int)
+
+	for _, app := range a.Applications {
+		for _, instance := range app.Instances {
+			counts[string(instance.Status)]++
+		}
+	}
+
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+
+	buff := &bytes.Buffer{}
+	for _, status := range statuses {
+		buff.WriteString(fmt.Sprintf("%s_%d_", status, counts[status]))
+	}
+
+	return buff.String()
+}
+
 type Application struct {
 	XMLName   xml.Name    `json:"-" xml:"application"`
 	Name      string      `json:"name" xml:"name"`
@@ -57,8 +107,19 @@ type RegistrationRequest struct {
 	Instance *Instance `json:"instance" xml:"instance"`
 }
 
+// The response of a single application lookup.
+type ApplicationResponse struct {
+	Application *Application `json:"application"`
+}
+
+// The response of a single instance lookup.
+type InstanceResponse struct {
+	Instance *Instance `json:"instance"`
+}
+
 // Information about a single instance.
 type Instance struct {
+	XMLName                       xml.Name    `json:"-" xml:"instance"`
 	InstanceID                    string      `json:"instanceId" xml:"instanceId"`
 	HostName                      string      `json:"hostName" xml:"hostName"`
 	App                           string      `json:"app" xml:"app"`
@@ -80,6 +141,91 @@ type Instance struct {
 	LastUpdatedTimestamp          string      `json:"lastUpdatedTimestamp" xml:"lastUpdatedTimestamp"`
 	LastDirtyTimestamp            string      `json:"lastDirtyTimestamp" xml:"lastDirtyTimestamp"`
 	ActionType                    string      `json:"actionType" xml:"actionType"`
+
+	// The json document the instance registered with, nil when the instance was not created from a registration.
+	registration json.RawMessage
+}
+
+// Parse the body of a registration request in the format of its content type, json when the content type is not xml.
+// The instance keeps the original json document so that it can be served back the way it was registered. An xml
+// registration keeps the fields of the instance, the elements that are unknown to the instance are not served back.
+func ParseRegistration(contentType string, body []byte) (*Instance, error) {
+
+	if strings.Contains(strings.ToLower(contentType), "xml") {
+		return parseXmlRegistration(body)
+	}
+
+	req := &struct {
+		Instance json.RawMessage `json:"instance"`
+	}{}
+
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, err
+	}
+
+	if req.Instance == nil {
+		return nil, fmt.Errorf("the registration does not contain an instance")
+	}
+
+	instance := &Instance{}
+
+	if err := json.Unmarshal(req.Instance, instance); err != nil {
+		return nil, err
+	}
+
+	instance.registration = req.Instance
+
+	return instance, nil
+}
+
+// The xml clients post the instance element itself instead of wrapping it the way the json clients do.
+func parseXmlRegistration(body []byte) (*Instance, error) {
+
+	instance := &Instance{}
+
+	if err := xml.Unmarshal(body, instance); err != nil {
+		return nil, err
+	}
+
+	if instance.App == "" && instance.InstanceID == "" {
+		return nil, fmt.Errorf("the registration does not contain an instance")
+	}
+
+	return instance, nil
+}
+
+// The fields that are managed by the registry, they are the only ones that change when a registered instance is served back.
+func (i *Instance) registryFields() map[string]interface{} {
+	return map[string]interface{}{
+		"status":           i.Status,
+		"overriddenstatus": i.OverriddenStatus,
+		"actionType":       i.ActionType,
+	}
+}
+
+func (i *Instance) MarshalJSON() ([]byte, error) {
+	type plainInstance Instance
+
+	if i.registration == nil {
+		return json.Marshal((*plainInstance)(i))
+	}
+
+	fields := make(map[string]json.RawMessage)
+
+	if err := json.Unmarshal(i.registration, &fields); err != nil {
+		return nil, err
+	}
+
+	for name, val := range i.registryFields() {
+		b, err := json.Marshal(val)
+		if err != nil {
+			return nil, err
+		}
+
+		fields[name] = b
+	}
+
+	return json.Marshal(fields)
 }
 
 func NewInstance(id, ip, hostName string, port int) *Instance {
@@ -135,12 +281,12 @@ func DefaultDataCenter() *DataCenter {
 }
 
 type Lease struct {
-	RenewalIntervalInSecs int
-	DurationInSecs        int
-	RegistrationTimestamp int
-	LastRenewalTimestamp  int
-	EvictionTimestamp     int
-	ServiceUpTimestamp    int
+	RenewalIntervalInSecs int   `json:"renewalIntervalInSecs" xml:"renewalIntervalInSecs"`
+	DurationInSecs        int   `json:"durationInSecs" xml:"durationInSecs"`
+	RegistrationTimestamp int64 `json:"registrationTimestamp" xml:"registrationTimestamp"`
+	LastRenewalTimestamp  int64 `json:"lastRenewalTimestamp" xml:"lastRenewalTimestamp"`
+	EvictionTimestamp     int64 `json:"evictionTimestamp" xml:"evictionTimestamp"`
+	ServiceUpTimestamp    int64 `json:"serviceUpTimestamp" xml:"serviceUpTimestamp"`
 }
 
 func DefaultLease() *Lease {
@@ -154,12 +300,118 @@ func DefaultLease() *Lease {
 	}
 }
 
+// Arbitrary key value pairs describing an instance, example: management.port, zone or version.
 type MetaData struct {
-	InstanceID string `json:"instanceId" xml:"instanceId"`
+	// The java type of the metadata map, example: java.util.Collections$EmptyMap
+	Class  string
+	Values map[string]string
 }
 
 func NewMetaData(ID string, port int) *MetaData {
 	return &MetaData{
-		InstanceID: fmt.Sprintf("%s:%d", ID, port),
+		Values: map[string]string{"instanceId": fmt.Sprintf("%s:%d", ID, port)},
 	}
 }
+
+// Retrieve the value of a metadata key, empty if the key is missing.
+func (m *MetaData) Get(key string) string {
+	return m.Values[key]
+}
+
+// The json representation is a flat object where the class is stored under the @class key.
+func (m *MetaData) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]string, len(m.Values)+1)
+
+	for key, val := range m.Values {
+		fields[key] = val
+	}
+
+	if m.Class != "" {
+		fields[metaDataClassKey] = m.Class
+	}
+
+	return json.Marshal(fields)
+}
+
+func (m *MetaData) UnmarshalJSON(b []byte) error {
+	fields := make(map[string]json.RawMessage)
+
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+
+	m.Values = make(map[string]string, len(fields))
+
+	for key, raw := range fields {
+		var val string
+
+		// eureka metadata values are strings, anything else is kept in its json form
+		if err := json.Unmarshal(raw, &val); err != nil {
+			val = string(raw)
+		}
+
+		if key == metaDataClassKey {
+			m.Class = val
+		} else {
+			m.Values[key] = val
+		}
+	}
+
+	return nil
+}
+
+// The xml representation has an element per key and the class as an attribute of the metadata element.
+func (m *MetaData) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if m.Class != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "class"}, Value: m.Class})
+	}
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(m.Values))
+	for key := range m.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := e.EncodeElement(m.Values[key], xml.StartElement{Name: xml.Name{Local: key}}); err != nil {
+			return err
+		}
+	}
+
+	return e.EncodeToken(start.End())
+}
+
+func (m *MetaData) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	m.Values = make(map[string]string)
+
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "class" {
+			m.Class = attr.Value
+		}
+	}
+
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			var val string
+			if err := d.DecodeElement(&val, &t); err != nil {
+				return err
+			}
+
+			m.Values[t.Name.Local] = val
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+const metaDataClassKey = "@class"
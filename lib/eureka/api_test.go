@@ -0,0 +1,113 @@
+package eureka
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetaDataJsonRoundTrip(t *testing.T) {
+	original := `{"management.port":"9101","version":"1.2.3","zone":"zone-a"}`
+
+	metaData := &MetaData{}
+	err := json.Unmarshal([]byte(original), metaData)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "9101", metaData.Get("management.port"))
+
+	result, err := json.Marshal(metaData)
+
+	assert.Nil(t, err)
+	assert.Equal(t, original, string(result))
+}
+
+func TestMetaDataJsonClass(t *testing.T) {
+	original := `{"@class":"java.util.Collections$EmptyMap"}`
+
+	metaData := &MetaData{}
+	err := json.Unmarshal([]byte(original), metaData)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "java.util.Collections$EmptyMap", metaData.Class)
+	assert.Empty(t, metaData.Values)
+
+	result, err := json.Marshal(metaData)
+
+	assert.Nil(t, err)
+	assert.Equal(t, original, string(result))
+}
+
+func TestMetaDataXmlRoundTrip(t *testing.T) {
+	original := `<instance><metadata><management.port>9101</management.port><version>1.2.3</version></metadata></instance>`
+
+	instance := &Instance{}
+	err := xml.Unmarshal([]byte(original), instance)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2.3", instance.MetaData.Get("version"))
+
+	result, err := xml.Marshal(instance)
+
+	assert.Nil(t, err)
+	assert.Contains(t, string(result), `<metadata><management.port>9101</management.port><version>1.2.3</version></metadata>`)
+}
+
+func TestMetaDataXmlClass(t *testing.T) {
+	original := `<instance><metadata class="java.util.Collections$EmptyMap"></metadata></instance>`
+
+	instance := &Instance{}
+	err := xml.Unmarshal([]byte(original), instance)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "java.util.Collections$EmptyMap", instance.MetaData.Class)
+	assert.Empty(t, instance.MetaData.Values)
+
+	result, err := xml.Marshal(instance)
+
+	assert.Nil(t, err)
+	assert.Contains(t, string(result), `<metadata class="java.util.Collections$EmptyMap"></metadata>`)
+}
+
+func TestRegistryKeepsMetaData(t *testing.T) {
+	original := `{"applications":{"application":[{"name":"FOO","instance":[{"instanceId":"foo:1","metadata":{"canary":"true","zone":"zone-a"}}]}]}}`
+
+	state := &State{}
+	err := json.Unmarshal([]byte(original), state)
+	assert.Nil(t, err)
+
+	result, err := json.Marshal(state)
+	assert.Nil(t, err)
+
+	reparsed := &State{}
+	err = json.Unmarshal(result, reparsed)
+	assert.Nil(t, err)
+
+	metaData := reparsed.Apps.Applications[0].Instances[0].MetaData
+	assert.Equal(t, map[string]string{"canary": "true", "zone": "zone-a"}, metaData.Values)
+
+	xmlResult, err := xml.Marshal(reparsed.Apps)
+	assert.Nil(t, err)
+
+	fromXml := &Applications{}
+	err = xml.Unmarshal(xmlResult, fromXml)
+	assert.Nil(t, err)
+
+	assert.Equal(t, metaData.Values, fromXml.Applications[0].Instances[0].MetaData.Values)
+}
+
+func TestReconcileHashCode(t *testing.T) {
+	up := &Instance{Status: UP}
+	down := &Instance{Status: DOWN}
+	outOfService := &Instance{Status: OUT_OF_SERVICE}
+
+	apps := &Applications{Applications: []*Application{
+		{Name: "FOO", Instances: []*Instance{up, outOfService, up}},
+		{Name: "BAR", Instances: []*Instance{down}},
+		{Name: "BAZ", Instances: []*Instance{}},
+	}}
+
+	assert.Equal(t, "DOWN_1_OUT_OF_SERVICE_1_UP_2_", apps.ReconcileHashCode())
+	assert.Equal(t, "", (&Applications{}).ReconcileHashCode())
+}
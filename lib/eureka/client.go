@@ -9,10 +9,54 @@ import (
 	"strings"
 
 	"github.com/ArthurHlt/go-eureka-client/eureka"
+	"github.com/newestuser/eureka-proxy/lib/tlsutil"
 )
 
+// A client of a remote eureka.
+type Client struct {
+	http *http.Client
+	// The transport of the applications lookups, nil keeps the one of the eureka client.
+	transport *http.Transport
+}
+
+// Create a client that connects to the remote eureka with the TLS settings, nil uses the defaults of the system.
+func NewClient(tls *tlsutil.ClientConfig) (*Client, error) {
+
+	if tls == nil {
+		return defaultClient, nil
+	}
+
+	transport, err := tls.Transport()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{http: &http.Client{Transport: transport}, transport: transport}, nil
+}
+
+var defaultClient = &Client{http: http.DefaultClient}
+
 func GetInstanceURL(host, id string) (string, error) {
+	return defaultClient.GetInstanceURL(host, id)
+}
+
+func RegisterInstance(eurekaHost string, instance *Instance) error {
+	return defaultClient.RegisterInstance(eurekaHost, instance)
+}
+
+//Example: localhost:8761, foo-service, FOO:8081
+func UnregisterApp(eurekaHost, app, hostName string) error {
+	return defaultClient.UnregisterApp(eurekaHost, app, hostName)
+}
+
+func (c *Client) GetInstanceURL(host, id string) (string, error) {
 	client := eureka.NewClient([]string{normalizeHost(host) + "/eureka"})
+
+	if c.transport != nil {
+		client.SetTransport(c.transport)
+	}
+
 	applications, err := client.GetApplications()
 
 	if err != nil {
@@ -30,7 +74,7 @@ func GetInstanceURL(host, id string) (string, error) {
 	return "", fmt.Errorf("no application in eureka matches the id: %v", id)
 }
 
-func RegisterInstance(eurekaHost string, instance *Instance) error {
+func (c *Client) RegisterInstance(eurekaHost string, instance *Instance) error {
 
 	url := fmt.Sprintf("%s/eureka/apps/%s", normalizeHost(eurekaHost), instance.App)
 
@@ -48,7 +92,7 @@ func RegisterInstance(eurekaHost string, instance *Instance) error {
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Accept", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.http.Do(req)
 
 	if err != nil {
 		return fmt.Errorf("could not register application with id: %s err: %s", instance.InstanceID, err.Error())
@@ -64,8 +108,7 @@ func RegisterInstance(eurekaHost string, instance *Instance) error {
 	return nil
 }
 
-//Example: localhost:8761, foo-service, FOO:8081
-func UnregisterApp(eurekaHost, app, hostName string) error {
+func (c *Client) UnregisterApp(eurekaHost, app, hostName string) error {
 
 	url := fmt.Sprintf("%s/eureka/apps/%s/%s", normalizeHost(eurekaHost), app, hostName)
 
@@ -75,18 +118,20 @@ func UnregisterApp(eurekaHost, app, hostName string) error {
 		return err
 	}
 
-	_, err = http.DefaultClient.Do(req)
+	resp, err := c.http.Do(req)
 
 	if err != nil {
 		return err
 	}
 
+	resp.Body.Close()
+
 	return nil
 }
 
 func normalizeHost(host string) string {
 
-	if strings.HasPrefix(host, "http://") {
+	if strings.HasPrefix(host, "http://") || strings.HasPrefix(host, "https://") {
 		return host
 	}
 
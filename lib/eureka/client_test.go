@@ -1,7 +1,16 @@
 package eureka
 
 import (
+	"encoding/pem"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/newestuser/eureka-proxy/lib/tlsutil"
 )
 
 // This is an integration test and eureka must be up and running
@@ -46,3 +55,59 @@ func testNormalizeLocalhost(t *testing.T) {
 		t.Errorf(" got: %s want: %s", got, want)
 	}
 }
+
+func TestFetchInstanceFromEurekaWithTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apps := &Applications{}
+		apps.AddApp(&Application{Name: "FOO-SERVICE", Instances: []*Instance{NewInstance("FOO-SERVICE", "127.0.0.1", "localhost", 8017)}})
+
+		body, _ := xml.Marshal(apps)
+
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "eureka")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClient(&tlsutil.ClientConfig{CAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := client.GetInstanceURL(server.URL, "foo-service")
+	want := "http://127.0.0.1:8017"
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if got != want {
+		t.Errorf(" got: %s want: %s", got, want)
+	}
+
+	// the certificate authorities of the settings are the only ones that are trusted
+	otherCA, _, err := tlsutil.SelfSignedCertificate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err = NewClient(&tlsutil.ClientConfig{CAFile: otherCA})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.GetInstanceURL(server.URL, "foo-service"); err == nil {
+		t.Error("the certificate of an untrusted eureka was accepted")
+	}
+}
@@ -0,0 +1,343 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+	"github.com/newestuser/eureka-proxy/lib/netutil"
+)
+
+// The path under which the fake applications can be managed while the proxy is running.
+const AdminPath = "/_proxy/api"
+
+// example: /_proxy/api/apps, /_proxy/api/apps/{appID} or /_proxy/api/apps/{appID}/{instanceID}
+var adminPattern = regexp.MustCompile(`^/_proxy/api/apps(?:/([^/]+))?(?:/([^/]+))?/?$`)
+
+// The representation of a fake application in the admin api.
+type appResource struct {
+	ID        string              `json:"id"`
+	Instances []*instanceResource `json:"instances"`
+}
+
+// The representation of a single instance of a fake application in the admin api.
+type instanceResource struct {
+	InstanceID       string         `json:"instanceId"`
+	Host             string         `json:"host"`
+	IP               string         `json:"ip"`
+	Port             int            `json:"port"`
+	Status           eureka2.Status `json:"status"`
+	OverriddenStatus eureka2.Status `json:"overriddenStatus,omitempty"`
+	// The following fields are only reported, they are ignored when an instance is added or changed.
+	Origin        string     `json:"origin,omitempty"`
+	Source        string     `json:"source,omitempty"`
+	LastHeartbeat *time.Time `json:"lastHeartbeat,omitempty"`
+}
+
+func newAppResource(clust *appCluster) *appResource {
+	instances := make([]*instanceResource, 0)
+
+	for _, target := range clust.Instances() {
+		instances = append(instances, newInstanceResource(target))
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].InstanceID < instances[j].InstanceID
+	})
+
+	return &appResource{ID: clust.ID, Instances: instances}
+}
+
+func newInstanceResource(target *Target) *instanceResource {
+	res := &instanceResource{
+		InstanceID:       target.InstanceID,
+		Host:             target.Host,
+		IP:               target.IP,
+		Port:             target.Port,
+		Status:           target.EffectiveStatus(),
+		OverriddenStatus: target.OverriddenStatus,
+		Origin:           target.Origin(),
+		Source:           target.source,
+	}
+
+	if !target.lastRenewal.IsZero() {
+		lastHeartbeat := target.lastRenewal
+		res.LastHeartbeat = &lastHeartbeat
+	}
+
+	return res
+}
+
+// Create the instance that is described by the resource, the host, ip and instance id default to the ones of this machine.
+func (res *instanceResource) newTarget(appID string) (*Target, error) {
+
+	if res.Port <= 0 {
+		return nil, badRequest("the port of the instance is required")
+	}
+
+	target := &Target{
+		InstanceID: res.InstanceID,
+		Host:       res.Host,
+		IP:         res.IP,
+		Port:       res.Port,
+		Status:     eureka2.Status(strings.ToUpper(string(res.Status))),
+		origin:     adminOrigin,
+	}
+
+	if target.Host == "" {
+		target.Host = netutil.Hostname()
+	}
+
+	if target.IP == "" {
+		target.IP = netutil.OutboundIP().String()
+	}
+
+	if target.InstanceID == "" {
+		target.InstanceID = fmt.Sprintf("%s:%s:%d", target.Host, appID, target.Port)
+	}
+
+	return target, nil
+}
+
+func (st *state) isAdminRequest(r *http.Request) bool {
+
+	return strings.HasPrefix(r.URL.Path, AdminPath)
+}
+
+// Serve the admin api that lists, adds, edits and removes the fake applications and their instances.
+// The changes are reported to the eureka clients through the delta, same as registrations.
+func (st *state) serveAdmin(w http.ResponseWriter, r *http.Request) {
+
+	matches := adminPattern.FindStringSubmatch(r.URL.Path)
+
+	if matches == nil {
+		respondWithError(w, r, notFound("unknown resource: %s", r.URL.Path))
+		return
+	}
+
+	appID, instanceID := matches[1], matches[2]
+
+	var body []byte
+
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		var err error
+
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			respondWithError(w, r, badRequest("could not read the request body err: %s", err.Error()))
+			return
+		}
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var entity interface{}
+	var err error
+	status := http.StatusOK
+
+	switch {
+	case appID == "" && r.Method == http.MethodGet:
+		entity = st.listApps()
+	case appID == "" && r.Method == http.MethodPost:
+		entity, err = st.addApp(body)
+		status = http.StatusCreated
+	case appID == "":
+		err = &statusError{status: http.StatusMethodNotAllowed, msg: fmt.Sprintf("method not allowed: %s", r.Method)}
+	case instanceID == "" && r.Method == http.MethodGet:
+		entity, err = st.getApp(appID)
+	case instanceID == "" && r.Method == http.MethodDelete:
+		entity, err = st.deleteApp(appID)
+	case instanceID == "":
+		err = &statusError{status: http.StatusMethodNotAllowed, msg: fmt.Sprintf("method not allowed: %s", r.Method)}
+	case r.Method == http.MethodGet:
+		entity, err = st.getInstance(appID, instanceID)
+	case r.Method == http.MethodPut:
+		entity, err = st.putInstance(appID, instanceID, body)
+	case r.Method == http.MethodDelete:
+		entity, err = st.deleteInstance(appID, instanceID)
+	default:
+		err = &statusError{status: http.StatusMethodNotAllowed, msg: fmt.Sprintf("method not allowed: %s", r.Method)}
+	}
+
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	bytes, err := json.Marshal(entity)
+
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
+
+func (st *state) listApps() []*appResource {
+	apps := make([]*appResource, 0, len(st.fakeApps))
+
+	for _, clust := range st.fakeApps {
+		apps = append(apps, newAppResource(clust))
+	}
+
+	sort.Slice(apps, func(i, j int) bool {
+		return apps[i].ID < apps[j].ID
+	})
+
+	return apps
+}
+
+func (st *state) getApp(appID string) (*appResource, error) {
+	clust := st.findCluster(appID)
+
+	if clust == nil {
+		return nil, notFound("unknown application: %s", appID)
+	}
+
+	return newAppResource(clust), nil
+}
+
+// Add the application with its instances, the instances are added to the existing ones when the application is already faked.
+func (st *state) addApp(body []byte) (*appResource, error) {
+	res := &appResource{}
+
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, badRequest("could not parse the application err: %s", err.Error())
+	}
+
+	if res.ID == "" {
+		return nil, badRequest("the id of the application is required")
+	}
+
+	app := &Application{ID: res.ID}
+
+	for _, instanceRes := range res.Instances {
+		target, err := instanceRes.newTarget(res.ID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		app.AddInstance(target)
+	}
+
+	if app.NoInstances() {
+		return nil, badRequest("the application requires at least one instance")
+	}
+
+	log.Printf("A service was added through the admin api. Injecting: %s\n", app)
+	st.addFakeApp(app)
+
+	return newAppResource(st.findCluster(res.ID)), nil
+}
+
+func (st *state) deleteApp(appID string) (*appResource, error) {
+	clust := st.findCluster(appID)
+
+	if clust == nil {
+		return nil, notFound("unknown application: %s", appID)
+	}
+
+	removed := newAppResource(clust)
+
+	for _, target := range clust.Instances() {
+		if ok, instance := clust.deregister(target.InstanceID); ok {
+			st.changes.record(eureka2.DELETED, clust.ID, instance)
+		}
+	}
+
+	log.Printf("A service was removed through the admin api. Removing: %s\n", clust.ID)
+	st.removeFakeApp(clust)
+
+	return removed, nil
+}
+
+func (st *state) getInstance(appID, instanceID string) (*instanceResource, error) {
+	clust := st.findCluster(appID)
+
+	if clust == nil {
+		return nil, notFound("unknown application: %s", appID)
+	}
+
+	target := clust.findTarget(instanceID)
+
+	if target == nil {
+		return nil, notFound("unknown instance: %s of application: %s", instanceID, appID)
+	}
+
+	return newInstanceResource(target), nil
+}
+
+// Add the instance or replace the existing one with the same id, the application is created when it is missing.
+// The instance is no longer evicted when it was replacing a registered one.
+func (st *state) putInstance(appID, instanceID string, body []byte) (*instanceResource, error) {
+	res := &instanceResource{}
+
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, badRequest("could not parse the instance err: %s", err.Error())
+	}
+
+	res.InstanceID = instanceID
+
+	target, err := res.newTarget(appID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if clust := st.findCluster(appID); clust != nil {
+		if existing := clust.findTarget(instanceID); existing != nil {
+			// the instance may be addressed with the id that is reported to the clients
+			target.InstanceID = existing.InstanceID
+
+			if clust.NewInstance(existing).InstanceID != clust.NewInstance(target).InstanceID {
+				// the clients know the fakes by the reported id which changes with the host and the port
+				clust.deregister(existing.InstanceID)
+				st.changes.record(eureka2.DELETED, clust.ID, existing)
+			}
+		}
+	}
+
+	app := &Application{ID: appID}
+	app.AddInstance(target)
+
+	log.Printf("An instance was changed through the admin api. Injecting: %s\n", app)
+	st.addFakeApp(app)
+
+	return newInstanceResource(target), nil
+}
+
+func (st *state) deleteInstance(appID, instanceID string) (*instanceResource, error) {
+	clust := st.findCluster(appID)
+
+	if clust == nil {
+		return nil, notFound("unknown application: %s", appID)
+	}
+
+	target := clust.findTarget(instanceID)
+
+	if target == nil {
+		return nil, notFound("unknown instance: %s of application: %s", instanceID, appID)
+	}
+
+	clust.deregister(target.InstanceID)
+	st.changes.record(eureka2.DELETED, clust.ID, target)
+
+	if clust.noInstances() {
+		st.removeFakeApp(clust)
+	}
+
+	log.Printf("An instance was removed through the admin api. Removing: %s instance: %s\n", clust.ID, target)
+
+	return newInstanceResource(target), nil
+}
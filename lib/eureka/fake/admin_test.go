@@ -0,0 +1,156 @@
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminAddApp(t *testing.T) {
+	handler := RequestHandler([]*Application{SingleInstanceApp("static", "static:1", "127.0.0.1", "localhost", 8080)}, false, time.Second, emptyUpstream())
+
+	status, body := admin(handler, http.MethodPost, "/_proxy/api/apps", `{"id": "FOO", "instances": [{"host": "my-laptop", "ip": "10.0.0.5", "port": 8081}]}`)
+
+	assert.Equal(t, http.StatusCreated, status)
+
+	app := &appResource{}
+	assert.Nil(t, json.Unmarshal([]byte(body), app), body)
+	assert.Equal(t, "FOO", app.ID)
+	assert.Len(t, app.Instances, 1)
+	assert.Equal(t, "my-laptop:FOO:8081", app.Instances[0].InstanceID)
+	assert.Equal(t, "10.0.0.5", app.Instances[0].IP)
+	assert.Equal(t, "admin", app.Instances[0].Origin)
+
+	status, body = admin(handler, http.MethodGet, "/_proxy/api/apps", "")
+
+	apps := make([]*appResource, 0)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, json.Unmarshal([]byte(body), &apps), body)
+	assert.Equal(t, []string{"FOO", "static"}, []string{apps[0].ID, apps[1].ID})
+
+	status, body = admin(handler, http.MethodGet, "/_proxy/api/apps/foo", "")
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"instanceId":"my-laptop:FOO:8081"`)
+
+	status, body = admin(handler, http.MethodGet, "/_proxy/api/apps/FOO/my-laptop:FOO:8081", "")
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"port":8081`)
+
+	// the clients receive the instance with their next delta
+	delta := fetchApps(t, handler, "/eureka/apps/delta")
+
+	assert.Equal(t, map[string]string{"my-laptop:foo:8081": eureka2.ADDED}, actions(delta, "FOO"))
+	assert.Equal(t, []string{"my-laptop:foo:8081"}, instanceIDs(fetchApps(t, handler, "/eureka/apps"), "FOO"))
+}
+
+func TestAdminPutInstance(t *testing.T) {
+	handler := RequestHandler(nil, false, time.Second, emptyUpstream())
+
+	// the application is created with the instance
+	status, _ := admin(handler, http.MethodPut, "/_proxy/api/apps/FOO/foo-1", `{"host": "my-laptop", "ip": "10.0.0.5", "port": 8081, "status": "up"}`)
+	assert.Equal(t, http.StatusOK, status)
+
+	status, body := admin(handler, http.MethodPut, "/_proxy/api/apps/FOO/foo-1", `{"host": "my-laptop", "ip": "10.0.0.5", "port": 8081, "status": "OUT_OF_SERVICE"}`)
+
+	res := &instanceResource{}
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, json.Unmarshal([]byte(body), res), body)
+	assert.Equal(t, eureka2.Status(eureka2.OUT_OF_SERVICE), res.Status)
+
+	delta := fetchApps(t, handler, "/eureka/apps/delta")
+
+	assert.Equal(t, map[string]string{"my-laptop:foo:8081": eureka2.MODIFIED}, actions(delta, "FOO"))
+
+	// the clients know the instance by the id that changes with the port
+	status, _ = admin(handler, http.MethodPut, "/_proxy/api/apps/FOO/foo-1", `{"host": "my-laptop", "ip": "10.0.0.5", "port": 8082}`)
+	assert.Equal(t, http.StatusOK, status)
+
+	delta = fetchApps(t, handler, "/eureka/apps/delta")
+
+	assert.Equal(t, map[string]string{"my-laptop:foo:8081": eureka2.DELETED, "my-laptop:foo:8082": eureka2.ADDED}, actions(delta, "FOO"))
+	assert.Equal(t, []string{"my-laptop:foo:8082"}, instanceIDs(fetchApps(t, handler, "/eureka/apps"), "FOO"))
+}
+
+func TestAdminDeleteInstanceAndApp(t *testing.T) {
+	handler := RequestHandler(nil, false, time.Second, emptyUpstream())
+
+	admin(handler, http.MethodPost, "/_proxy/api/apps", `{"id": "FOO", "instances": [{"host": "my-laptop", "port": 8081}, {"host": "my-laptop", "port": 8082}]}`)
+	admin(handler, http.MethodPost, "/_proxy/api/apps", `{"id": "BAR", "instances": [{"host": "my-laptop", "port": 9091}]}`)
+
+	status, _ := admin(handler, http.MethodDelete, "/_proxy/api/apps/FOO/my-laptop:FOO:8081", "")
+	assert.Equal(t, http.StatusOK, status)
+
+	status, _ = admin(handler, http.MethodDelete, "/_proxy/api/apps/BAR", "")
+	assert.Equal(t, http.StatusOK, status)
+
+	delta := fetchApps(t, handler, "/eureka/apps/delta")
+
+	assert.Equal(t, map[string]string{"my-laptop:foo:8081": eureka2.DELETED, "my-laptop:foo:8082": eureka2.ADDED}, actions(delta, "FOO"))
+	assert.Equal(t, map[string]string{"my-laptop:bar:9091": eureka2.DELETED}, actions(delta, "BAR"))
+
+	// the application is removed with its last instance
+	status, _ = admin(handler, http.MethodDelete, "/_proxy/api/apps/FOO/my-laptop:FOO:8082", "")
+	assert.Equal(t, http.StatusOK, status)
+
+	status, _ = admin(handler, http.MethodGet, "/_proxy/api/apps/FOO", "")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	_, body := admin(handler, http.MethodGet, "/_proxy/api/apps", "")
+	assert.Equal(t, "[]", body)
+}
+
+func TestAdminErrors(t *testing.T) {
+	handler := RequestHandler([]*Application{SingleInstanceApp("static", "static:1", "127.0.0.1", "localhost", 8080)}, false, time.Second, emptyUpstream())
+
+	tests := []struct {
+		method   string
+		path     string
+		body     string
+		expected int
+	}{
+		{http.MethodGet, "/_proxy/api/unknown", "", http.StatusNotFound},
+		{http.MethodGet, "/_proxy/api/apps/FOO", "", http.StatusNotFound},
+		{http.MethodDelete, "/_proxy/api/apps/FOO", "", http.StatusNotFound},
+		{http.MethodGet, "/_proxy/api/apps/STATIC/unknown", "", http.StatusNotFound},
+		{http.MethodDelete, "/_proxy/api/apps/FOO/unknown", "", http.StatusNotFound},
+		{http.MethodPost, "/_proxy/api/apps", "not json", http.StatusBadRequest},
+		{http.MethodPost, "/_proxy/api/apps", `{"instances": [{"port": 8081}]}`, http.StatusBadRequest},
+		{http.MethodPost, "/_proxy/api/apps", `{"id": "FOO", "instances": []}`, http.StatusBadRequest},
+		{http.MethodPost, "/_proxy/api/apps", `{"id": "FOO", "instances": [{"host": "my-laptop"}]}`, http.StatusBadRequest},
+		{http.MethodPut, "/_proxy/api/apps/FOO/foo-1", "not json", http.StatusBadRequest},
+		{http.MethodPut, "/_proxy/api/apps/FOO/foo-1", `{"host": "my-laptop"}`, http.StatusBadRequest},
+		{http.MethodDelete, "/_proxy/api/apps", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/_proxy/api/apps/STATIC", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/_proxy/api/apps/STATIC/static:1", "", http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		status, body := admin(handler, test.method, test.path, test.body)
+
+		assert.Equal(t, test.expected, status, test.method+" "+test.path+" "+body)
+	}
+
+	// none of the failed requests changed the fakes
+	_, body := admin(handler, http.MethodGet, "/_proxy/api/apps", "")
+
+	apps := make([]*appResource, 0)
+	assert.Nil(t, json.Unmarshal([]byte(body), &apps), body)
+	assert.Len(t, apps, 1)
+	assert.Empty(t, fetchApps(t, handler, "/eureka/apps/delta").Applications)
+}
+
+func admin(handler http.Handler, method, path, body string) (int, string) {
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+
+	return rec.Code, strings.TrimSpace(rec.Body.String())
+}
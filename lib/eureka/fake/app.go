@@ -4,12 +4,13 @@ import (
 	"fmt"
 	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
 	"strings"
+	"time"
 )
 
 // A representation of a single application with multiple instances
 type Application struct {
 	ID      string
-	targets map[string] *Target
+	targets map[string]*Target
 }
 
 // A representation of a single application instance
@@ -18,12 +19,80 @@ type Target struct {
 	Host       string
 	Port       int
 	IP         string
+	// The status the instance registered with, empty when it is not known.
+	Status eureka2.Status
+	// The status set through the status override endpoint, it takes precedence over the registered one.
+	OverriddenStatus eureka2.Status
+	// The instance as it was registered, nil for the fakes that did not register.
+	Registration *eureka2.Instance
+
+	// The lease the instance registered with, zero when it did not provide one.
+	leaseDuration time.Duration
+	// The time of the last registration or heartbeat, zero when the instance never registered.
+	lastRenewal time.Time
+	// How the instance was added to the fakes.
+	origin origin
+	// The request that added the instance, empty when it was not added by a service.
+	source string
+}
+
+// How an instance was added to the fakes.
+type origin string
+
+const (
+	// From the configuration file or the program arguments.
+	staticOrigin origin = "static"
+	// Detected from the registration or the heartbeat of a service.
+	detectedOrigin origin = "detected"
+	// Through the admin api.
+	adminOrigin origin = "admin"
+)
+
+// The origin of the instance, the instances without one are the ones from the configuration.
+func (t *Target) Origin() string {
+	if t.origin == "" {
+		return string(staticOrigin)
+	}
+
+	return string(t.origin)
 }
 
 func (t *Target) String() string {
 	return fmt.Sprintf("Instance{id=%s, host=%s, port=%d, ip=%s}", t.InstanceID, t.Host, t.Port, t.IP)
 }
 
+// The status that is reported to the eureka clients, an overridden status takes precedence over the registered one.
+func (t *Target) EffectiveStatus() eureka2.Status {
+	if t.OverriddenStatus != "" && t.OverriddenStatus != eureka2.UNKNOWN {
+		return t.OverriddenStatus
+	}
+
+	if t.Status != "" {
+		return t.Status
+	}
+
+	return eureka2.UP
+}
+
+// Check if the instance is reported differently to the eureka clients than the other one.
+func (t *Target) isChangedFrom(other *Target) bool {
+	return t.EffectiveStatus() != other.EffectiveStatus() || t.Host != other.Host || t.IP != other.IP || t.Port != other.Port
+}
+
+// Check if the lease of the instance expired. Instances that never registered do not expire.
+func (t *Target) isExpired(now time.Time, defaultLease time.Duration) bool {
+	if t.lastRenewal.IsZero() || defaultLease == 0 {
+		return false
+	}
+
+	lease := t.leaseDuration
+	if lease == 0 {
+		lease = defaultLease
+	}
+
+	return now.Sub(t.lastRenewal) > lease
+}
+
 // Create a single app with one instance
 func SingleInstanceApp(appID, instanceID, IP, host string, port int) *Application {
 
@@ -34,6 +103,31 @@ func SingleInstanceApp(appID, instanceID, IP, host string, port int) *Applicatio
 	return app
 }
 
+// Create an application with the single instance that registered.
+func RegisteredApp(instance *eureka2.Instance) *Application {
+
+	target := &Target{
+		InstanceID:   instance.InstanceID,
+		Host:         instance.HostName,
+		IP:           instance.IPAddress,
+		Status:       instance.Status,
+		Registration: instance,
+	}
+
+	if instance.Port != nil {
+		target.Port = instance.Port.Number
+	}
+
+	if instance.Lease != nil && instance.Lease.DurationInSecs > 0 {
+		target.leaseDuration = time.Duration(instance.Lease.DurationInSecs) * time.Second
+	}
+
+	app := &Application{ID: instance.App}
+	app.AddInstance(target)
+
+	return app
+}
+
 func (app *Application) String() string {
 
 	return fmt.Sprintf("FakeApp{id=%s, instances=%s}", app.ID, app.Instances())
@@ -50,12 +144,44 @@ func (app *Application) NewInstances() []*eureka2.Instance {
 	inst := make([]*eureka2.Instance, 0)
 
 	for _, target := range app.targets {
-		inst = append(inst, eureka2.NewInstance(app.ID, target.IP, target.Host, target.Port))
+		inst = append(inst, app.NewInstance(target))
 	}
 
 	return inst
 }
 
+// Create the eureka representation of a single instance of the application.
+// Instances that registered are served the way they registered, only the fields managed by the registry are changed.
+func (app *Application) NewInstance(target *Target) *eureka2.Instance {
+	var instance *eureka2.Instance
+
+	if target.Registration != nil {
+		registered := *target.Registration
+		instance = &registered
+		instance.ActionType = eureka2.ADDED
+	} else {
+		instance = eureka2.NewInstance(app.ID, target.IP, target.Host, target.Port)
+	}
+
+	instance.Status = target.EffectiveStatus()
+
+	if target.OverriddenStatus != "" {
+		instance.OverriddenStatus = target.OverriddenStatus
+	} else if target.Registration != nil {
+		instance.OverriddenStatus = eureka2.UNKNOWN
+	}
+
+	return instance
+}
+
+// Check if the application contains an instance with the given id.
+func (app *Application) HasInstance(instanceID string) bool {
+	app.initIfNil()
+
+	_, ok := app.targets[strings.ToLower(instanceID)]
+	return ok
+}
+
 // Remove a single instance from the application. Return true if the successfully removed, false otherwise.
 func (app *Application) RemoveInstance(instanceID string) (bool, *Target) {
 	app.initIfNil()
@@ -100,6 +226,7 @@ func (app *Application) Instances() []*Target {
 	i := 0
 	for _, v := range app.targets {
 		targets[i] = v
+		i++
 	}
 
 	return targets
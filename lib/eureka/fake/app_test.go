@@ -0,0 +1,123 @@
+package fake
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+	"github.com/stretchr/testify/assert"
+)
+
+const richRegistration = `{"instance":{"instanceId":"my-laptop:foo:9001","app":"FOO","hostName":"my-laptop","ipAddr":"10.0.0.5","status":"UP",` +
+	`"overriddenstatus":"UNKNOWN","port":{"$":9001,"@enabled":"true"},"securePort":{"$":9443,"@enabled":"true"},"countryId":1,` +
+	`"dataCenterInfo":{"@class":"com.netflix.appinfo.MyDataCenterInfo","name":"MyOwn"},` +
+	`"leaseInfo":{"renewalIntervalInSecs":5,"durationInSecs":15},` +
+	`"metadata":{"management.port":"9101","version":"1.2.3","zone":"zone-b"},` +
+	`"homePageUrl":"http://my-laptop:9001/","statusPageUrl":"http://my-laptop:9101/actuator/info","healthCheckUrl":"http://my-laptop:9101/actuator/health",` +
+	`"vipAddress":"foo","secureVipAddress":"foo","isCoordinatingDiscoveryServer":"false",` +
+	`"lastUpdatedTimestamp":"1700000000000","lastDirtyTimestamp":"1700000000001","customField":{"nested":[1,2]}}}`
+
+func TestRegistrationServedBack(t *testing.T) {
+	handler := RequestHandler(nil, false, time.Minute, emptyUpstream())
+
+	assert.Equal(t, http.StatusNoContent, serve(handler, http.MethodPost, "/eureka/apps/FOO", richRegistration))
+
+	expected := registeredFields(t, richRegistration)
+	expected["actionType"] = eureka2.ADDED
+
+	rec := lookup(handler, "/eureka/apps", "")
+	apps := &struct {
+		Applications struct {
+			Application []struct {
+				Instance []map[string]interface{} `json:"instance"`
+			} `json:"application"`
+		} `json:"applications"`
+	}{}
+
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), apps), rec.Body.String())
+	assert.Equal(t, expected, apps.Applications.Application[0].Instance[0])
+
+	for _, path := range []string{"/eureka/apps/FOO/my-laptop:foo:9001", "/eureka/instances/my-laptop:foo:9001"} {
+		rec := lookup(handler, path, "")
+
+		assert.Equal(t, expected, registeredFields(t, rec.Body.String()), path)
+	}
+
+	// only the fields managed by the registry change
+	serve(handler, http.MethodPut, "/eureka/apps/FOO/my-laptop:foo:9001/status?value=OUT_OF_SERVICE", "")
+
+	expected["status"] = eureka2.OUT_OF_SERVICE
+	expected["overriddenstatus"] = eureka2.OUT_OF_SERVICE
+
+	assert.Equal(t, expected, registeredFields(t, lookup(handler, "/eureka/instances/my-laptop:foo:9001", "").Body.String()))
+}
+
+func TestRegistrationServedBackAsXml(t *testing.T) {
+	handler := RequestHandler(nil, false, time.Minute, emptyUpstream())
+
+	serve(handler, http.MethodPost, "/eureka/apps/FOO", richRegistration)
+
+	rec := lookup(handler, "/eureka/apps/FOO/my-laptop:foo:9001", "application/xml")
+
+	instance := &eureka2.Instance{}
+	assert.Nil(t, xml.Unmarshal(rec.Body.Bytes(), instance), rec.Body.String())
+	assert.Equal(t, map[string]string{"management.port": "9101", "version": "1.2.3", "zone": "zone-b"}, instance.MetaData.Values)
+	assert.Equal(t, 9443, instance.SecurePort.Number)
+	assert.Equal(t, "http://my-laptop:9101/actuator/health", instance.HealthCheckURL)
+	assert.Equal(t, "1700000000000", instance.LastUpdatedTimestamp)
+}
+
+func TestXmlRegistrationServedBack(t *testing.T) {
+	handler := RequestHandler(nil, false, time.Minute, emptyUpstream())
+
+	body := `<instance><instanceId>my-laptop:foo:9001</instanceId><hostName>my-laptop</hostName><app>FOO</app><ipAddr>10.0.0.5</ipAddr>` +
+		`<status>UP</status><overriddenstatus>UNKNOWN</overriddenstatus><port enabled="true">9001</port><securePort enabled="true">9443</securePort>` +
+		`<countryId>1</countryId><metadata><management.port>9101</management.port><zone>zone-b</zone></metadata>` +
+		`<healthCheckUrl>http://my-laptop:9101/actuator/health</healthCheckUrl><vipAddress>foo</vipAddress>` +
+		`<lastUpdatedTimestamp>1700000000000</lastUpdatedTimestamp></instance>`
+
+	r := httptest.NewRequest(http.MethodPost, "/eureka/apps/FOO", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/xml")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	asXml := &eureka2.Instance{}
+	rec = lookup(handler, "/eureka/apps/FOO/my-laptop:foo:9001", "application/xml")
+	assert.Nil(t, xml.Unmarshal(rec.Body.Bytes(), asXml), rec.Body.String())
+
+	for _, instance := range []*eureka2.Instance{fetchInstance(t, handler, "/eureka/apps/FOO/my-laptop:foo:9001"), asXml} {
+		assert.Equal(t, "10.0.0.5", instance.IPAddress)
+		assert.Equal(t, map[string]string{"management.port": "9101", "zone": "zone-b"}, instance.MetaData.Values)
+		assert.Equal(t, 9443, instance.SecurePort.Number)
+		assert.Equal(t, "http://my-laptop:9101/actuator/health", instance.HealthCheckURL)
+		assert.Equal(t, "1700000000000", instance.LastUpdatedTimestamp)
+	}
+
+	// the body is parsed in the format of its content type
+	r = httptest.NewRequest(http.MethodPost, "/eureka/apps/FOO", strings.NewReader(richRegistration))
+	r.Header.Set("Content-Type", "application/xml")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// The fields of the instance in a registration or an instance lookup.
+func registeredFields(t *testing.T, body string) map[string]interface{} {
+	res := &struct {
+		Instance map[string]interface{} `json:"instance"`
+	}{}
+
+	assert.Nil(t, json.Unmarshal([]byte(body), res), body)
+
+	return res.Instance
+}
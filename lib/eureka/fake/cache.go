@@ -0,0 +1,128 @@
+package fake
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/newestuser/eureka-proxy/lib/httputil"
+)
+
+// A handler that caches the registries of the remote eureka so that the clients polling through the proxy do not
+// reach the remote eureka on every fetch. The registries are served from the cache until the ttl passes, after that the cached
+// registry is served while it is refreshed in the background. When the registry could not be refreshed for the max stale duration
+// it is fetched with the request, the cached registry is served only when the remote eureka cannot be reached.
+// The rest of the requests are passed to the remote eureka as they are.
+type Cache struct {
+	upstream http.Handler
+	ttl      time.Duration
+	maxStale time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+// A registry as the remote eureka responded with it.
+type cacheEntry struct {
+	rec        *httputil.HttpResponseRecorder
+	fetched    time.Time
+	refreshing bool
+}
+
+// Create a cache of the registries of the upstream, the max stale duration is counted from the time the registry was fetched.
+func CachingHandler(upstream http.Handler, ttl, maxStale time.Duration) *Cache {
+	if maxStale < ttl {
+		maxStale = ttl
+	}
+
+	return &Cache{upstream: upstream, ttl: ttl, maxStale: maxStale, now: time.Now, entries: make(map[string]*cacheEntry)}
+}
+
+func (c *Cache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet || !registryPattern.MatchString(r.URL.Path) {
+		c.upstream.ServeHTTP(w, r)
+		return
+	}
+
+	// the same registry is cached separately for every representation of it
+	key := r.URL.Path + "?" + r.URL.RawQuery + "|" + r.Header.Get("Accept") + "|" + r.Header.Get("Accept-Encoding")
+
+	c.mu.Lock()
+	entry := c.entries[key]
+
+	if entry != nil {
+		age := c.now().Sub(entry.fetched)
+
+		if age < c.ttl {
+			c.mu.Unlock()
+			entry.rec.CopyTo(w)
+			return
+		}
+
+		if age < c.maxStale {
+			if !entry.refreshing {
+				entry.refreshing = true
+				go c.refresh(key, r.Clone(context.Background()))
+			}
+
+			c.mu.Unlock()
+			entry.rec.CopyTo(w)
+			return
+		}
+	}
+
+	c.mu.Unlock()
+
+	rec := c.fetch(key, r)
+
+	if rec.Status() >= http.StatusInternalServerError && entry != nil {
+		log.Printf("The registry %s could not be fetched, serving the one from %s ago, status: %d\n", r.URL.Path, c.now().Sub(entry.fetched).Round(time.Second), rec.Status())
+		entry.rec.CopyTo(w)
+		return
+	}
+
+	rec.CopyTo(w)
+}
+
+// Remove all the cached registries, the next fetches reach the remote eureka.
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*cacheEntry)
+}
+
+func (c *Cache) refresh(key string, r *http.Request) {
+
+	rec := c.fetch(key, r)
+
+	if rec.Status() != http.StatusOK {
+		log.Printf("The registry %s could not be refreshed, status: %d\n", r.URL.Path, rec.Status())
+
+		c.mu.Lock()
+		if entry := c.entries[key]; entry != nil {
+			entry.refreshing = false
+		}
+		c.mu.Unlock()
+	}
+}
+
+// Fetch the registry from the upstream and cache it when it was fetched successfully.
+func (c *Cache) fetch(key string, r *http.Request) *httputil.HttpResponseRecorder {
+
+	rec := httputil.DetachedRecorder()
+
+	c.upstream.ServeHTTP(rec, r)
+
+	if rec.Status() == http.StatusOK {
+		c.mu.Lock()
+		c.entries[key] = &cacheEntry{rec: rec, fetched: c.now()}
+		c.mu.Unlock()
+	}
+
+	return rec
+}
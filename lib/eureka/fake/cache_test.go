@@ -0,0 +1,155 @@
+package fake
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheServesFreshRegistry(t *testing.T) {
+	upstream := &countingUpstream{status: http.StatusOK}
+	cache, _ := testCache(upstream)
+
+	assert.Equal(t, http.StatusOK, serve(cache, http.MethodGet, "/eureka/apps", ""))
+	assert.Equal(t, http.StatusOK, serve(cache, http.MethodGet, "/eureka/apps", ""))
+	assert.Equal(t, http.StatusOK, serve(cache, http.MethodGet, "/eureka/apps/delta", ""))
+
+	assert.Equal(t, 2, upstream.count())
+
+	serve(cache, http.MethodPost, "/eureka/apps/FOO", "")
+	serve(cache, http.MethodPost, "/eureka/apps/FOO", "")
+
+	assert.Equal(t, 4, upstream.count())
+}
+
+func TestCacheRefreshesStaleRegistryInBackground(t *testing.T) {
+	upstream := &countingUpstream{status: http.StatusOK}
+	cache, clock := testCache(upstream)
+
+	serve(cache, http.MethodGet, "/eureka/apps", "")
+	clock.add(15 * time.Second)
+
+	upstream.setStatus(http.StatusBadGateway)
+
+	// the stale registry is served until the max stale duration passes
+	assert.Equal(t, http.StatusOK, serve(cache, http.MethodGet, "/eureka/apps", ""))
+	assert.Equal(t, 2, upstream.awaitCount(2))
+
+	upstream.setStatus(http.StatusOK)
+
+	for i := 0; i < 100 && upstream.count() < 3; i++ {
+		assert.Equal(t, http.StatusOK, serve(cache, http.MethodGet, "/eureka/apps", ""))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the refreshed registry is fresh again
+	time.Sleep(50 * time.Millisecond)
+	count := upstream.count()
+	serve(cache, http.MethodGet, "/eureka/apps", "")
+
+	assert.Equal(t, count, upstream.count())
+}
+
+func TestCacheServesStaleRegistryWhenUpstreamIsUnreachable(t *testing.T) {
+	upstream := &countingUpstream{status: http.StatusOK}
+	cache, clock := testCache(upstream)
+
+	serve(cache, http.MethodGet, "/eureka/apps", "")
+	clock.add(2 * time.Minute)
+
+	upstream.setStatus(http.StatusBadGateway)
+
+	assert.Equal(t, http.StatusOK, serve(cache, http.MethodGet, "/eureka/apps", ""))
+	assert.Equal(t, 2, upstream.count())
+
+	upstream.setStatus(http.StatusNotFound)
+
+	assert.Equal(t, http.StatusNotFound, serve(cache, http.MethodGet, "/eureka/apps", ""))
+}
+
+func TestCacheClear(t *testing.T) {
+	upstream := &countingUpstream{status: http.StatusOK}
+	cache, _ := testCache(upstream)
+
+	serve(cache, http.MethodGet, "/eureka/apps", "")
+	cache.Clear()
+	serve(cache, http.MethodGet, "/eureka/apps", "")
+
+	assert.Equal(t, 2, upstream.count())
+}
+
+// A cache with a ttl of 10s and a max stale duration of 1m that uses a clock which only moves when it is told to.
+func testCache(upstream http.Handler) (*Cache, *testClock) {
+	clock := &testClock{t: time.Now()}
+
+	cache := CachingHandler(upstream, 10*time.Second, time.Minute)
+	cache.now = clock.now
+
+	return cache, clock
+}
+
+type testClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *testClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.t
+}
+
+func (c *testClock) add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.t = c.t.Add(d)
+}
+
+// An upstream that counts the requests it received and responds with an empty registry unless it is told to fail.
+type countingUpstream struct {
+	mu       sync.Mutex
+	status   int
+	requests int
+}
+
+func (u *countingUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	u.requests++
+	status := u.status
+	u.mu.Unlock()
+
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+
+	emptyUpstream().ServeHTTP(w, r)
+}
+
+func (u *countingUpstream) count() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.requests
+}
+
+func (u *countingUpstream) setStatus(status int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.status = status
+}
+
+// Wait for the background refresh to reach the upstream, return the count once it is reached or after a second.
+func (u *countingUpstream) awaitCount(count int) int {
+	for i := 0; i < 100 && u.count() < count; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return u.count()
+}
@@ -5,6 +5,7 @@ import (
 	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
 	"net/http"
 	"strings"
+	"time"
 )
 
 // A representation of a fake application that will be injected in the list of eureka applications.
@@ -53,12 +54,114 @@ func (clust *appCluster) NewEurekaApp() *eureka2.Application {
 	return clust.Application.NewEurekaApp()
 }
 
+// Create an application containing only the instances registered with the vip address,
+// return nil if there are no such instances.
+func (clust *appCluster) NewVipApp(vipAddress string, secure bool) *eureka2.Application {
+
+	app := clust.NewEurekaApp()
+	instances := make([]*eureka2.Instance, 0)
+
+	for _, instance := range app.Instances {
+		instanceVipAddress := instance.VIPAddress
+		if secure {
+			instanceVipAddress = instance.SecureVIPAddress
+		}
+
+		if matchesVipAddress(instanceVipAddress, vipAddress) {
+			instances = append(instances, instance)
+		}
+	}
+
+	if len(instances) == 0 {
+		return nil
+	}
+
+	app.ReplaceInstances(instances)
+
+	return app
+}
+
+// Check if the request is overriding the status of an instance, example: PUT /eureka/apps/{appID}/{instanceID}/status?value=OUT_OF_SERVICE
+// or removing the override, example: DELETE /eureka/apps/{appID}/{instanceID}/status?value=UP
+func (clust *appCluster) isStatusUpdateRequest(r *http.Request) (bool, string) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		return false, ""
+	}
+
+	matches := statusUpdatePattern.FindStringSubmatch(r.URL.Path)
+
+	if matches == nil || !strings.EqualFold(matches[1], clust.ID) {
+		return false, ""
+	}
+
+	return true, matches[2]
+}
+
+// Override the status of a single instance. Return true if the instance exists, false otherwise.
+func (clust *appCluster) overrideStatus(instanceID string, status eureka2.Status) (bool, *Target) {
+
+	target := clust.findTarget(instanceID)
+
+	if target == nil {
+		return false, nil
+	}
+
+	target.OverriddenStatus = status
+
+	return true, target
+}
+
+// Remove the status override of a single instance, the instance will report the status it registered with
+// unless a new status is provided. Return true if the instance exists, false otherwise.
+func (clust *appCluster) removeStatusOverride(instanceID string, status eureka2.Status) (bool, *Target) {
+
+	target := clust.findTarget(instanceID)
+
+	if target == nil {
+		return false, nil
+	}
+
+	target.OverriddenStatus = ""
+
+	if status != "" {
+		target.Status = status
+	}
+
+	return true, target
+}
+
+func (clust *appCluster) successfulStatusUpdate(w http.ResponseWriter) {
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (clust *appCluster) unknownInstance(w http.ResponseWriter) {
+
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// Renew the lease of the instance that sent the heartbeat. Return true if the instance exists, false otherwise.
+func (clust *appCluster) renew(r *http.Request, now time.Time) bool {
+
+	_, _, instanceID := parseAppLookup(r.URL.Path)
+
+	target := clust.findTarget(instanceID)
+
+	if target == nil {
+		return false
+	}
+
+	target.lastRenewal = now
+
+	return true
+}
+
 func (clust *appCluster) isRegistrationRequest(r *http.Request) bool {
 	if r.Method != http.MethodPost {
 		return false
 	}
 
-	return caseInsensitiveContains(r.URL.Path, fmt.Sprintf("eureka/apps/%s", clust.ID))
+	return clust.isTargetedBy(r)
 }
 
 func (clust *appCluster) successfullyRegister(w http.ResponseWriter) {
@@ -72,7 +175,15 @@ func (clust *appCluster) isHeartbeatRequest(r *http.Request) bool {
 		return false
 	}
 
-	return caseInsensitiveContains(r.URL.Path, fmt.Sprintf("eureka/apps/%s", clust.ID))
+	return clust.isTargetedBy(r)
+}
+
+// Check if the request is sent to the path of the application, the ids of the other applications may start with the same id.
+func (clust *appCluster) isTargetedBy(r *http.Request) bool {
+
+	ok, appID, _ := parseAppLookup(r.URL.Path)
+
+	return ok && strings.EqualFold(appID, clust.ID)
 }
 
 func (clust *appCluster) successfulHeartbeat(w http.ResponseWriter) {
@@ -80,13 +191,56 @@ func (clust *appCluster) successfulHeartbeat(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// Check if the request is looking up the application or a single instance of the application.
 func (clust *appCluster) isRequestingInstances(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
 
-	return false
+	return clust.isTargetedBy(r)
 }
 
-func (clust *appCluster) returnInstances(w http.ResponseWriter) {
+func (clust *appCluster) returnInstances(w http.ResponseWriter, r *http.Request) {
+
+	_, _, instanceID := parseAppLookup(r.URL.Path)
 
+	if instanceID == "" {
+		writeEntity(w, r, &eureka2.ApplicationResponse{Application: clust.NewEurekaApp()}, clust.NewEurekaApp())
+		return
+	}
+
+	instance := clust.findInstance(instanceID)
+
+	if instance == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	writeEntity(w, r, &eureka2.InstanceResponse{Instance: instance}, instance)
+}
+
+// Find an instance of the application by its id.
+// The id can either be the one the instance was registered with or the one that is reported to the eureka clients.
+func (clust *appCluster) findInstance(instanceID string) *eureka2.Instance {
+
+	if target := clust.findTarget(instanceID); target != nil {
+		return clust.NewInstance(target)
+	}
+
+	return nil
+}
+
+func (clust *appCluster) findTarget(instanceID string) *Target {
+
+	for _, target := range clust.Instances() {
+		instance := clust.NewInstance(target)
+
+		if strings.EqualFold(target.InstanceID, instanceID) || strings.EqualFold(instance.InstanceID, instanceID) {
+			return target
+		}
+	}
+
+	return nil
 }
 
 func (clust *appCluster) isDeregistrationRequest(r *http.Request) (bool, string) {
@@ -94,16 +248,16 @@ func (clust *appCluster) isDeregistrationRequest(r *http.Request) (bool, string)
 		return false, ""
 	}
 
-	serviceUrlPath := fmt.Sprintf("eureka/apps/%s/", clust.ID)
+	ok, appID, instanceID := parseAppLookup(r.URL.Path)
 
-	if caseInsensitiveContains(r.URL.Path, serviceUrlPath) {
-		return true, strings.Split(r.URL.Path, serviceUrlPath)[1]
+	if !ok || instanceID == "" || !strings.EqualFold(appID, clust.ID) {
+		return false, ""
 	}
 
-	return false, ""
+	return true, instanceID
 }
 
 func (clust *appCluster) successfullyDeregister(w http.ResponseWriter) {
 
-	w.WriteHeader(http.StatusNotFound)
+	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,110 @@
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusOverride(t *testing.T) {
+	handler := RequestHandler(nil, false, time.Minute, emptyUpstream())
+
+	serve(handler, http.MethodPost, "/eureka/apps/FOO", registration("FOO", "localhost:foo:9001", 9001))
+
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodPut, "/eureka/apps/FOO/localhost:foo:9001/status?value=OUT_OF_SERVICE", ""))
+
+	instance := fetchInstance(t, handler, "/eureka/apps/FOO/localhost:foo:9001")
+
+	assert.Equal(t, eureka2.Status(eureka2.OUT_OF_SERVICE), instance.Status)
+	assert.Equal(t, eureka2.Status(eureka2.OUT_OF_SERVICE), instance.OverriddenStatus)
+	assert.Equal(t, map[string]string{"localhost:foo:9001": eureka2.MODIFIED}, actions(fetchApps(t, handler, "/eureka/apps/delta"), "FOO"))
+	assert.Equal(t, "OUT_OF_SERVICE_1_", fetchApps(t, handler, "/eureka/apps").HashCode)
+
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodPut, "/eureka/apps/foo/localhost:foo:9001/status?value=down", ""))
+	assert.Equal(t, eureka2.Status(eureka2.DOWN), fetchInstance(t, handler, "/eureka/apps/FOO/localhost:foo:9001").Status)
+
+	// removing the override restores the status the instance registered with
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodDelete, "/eureka/apps/FOO/localhost:foo:9001/status", ""))
+
+	instance = fetchInstance(t, handler, "/eureka/apps/FOO/localhost:foo:9001")
+
+	assert.Equal(t, eureka2.Status(eureka2.UP), instance.Status)
+	assert.Equal(t, eureka2.Status(eureka2.UNKNOWN), instance.OverriddenStatus)
+
+	// unless a new status is provided
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodDelete, "/eureka/apps/FOO/localhost:foo:9001/status?value=DOWN", ""))
+	assert.Equal(t, eureka2.Status(eureka2.DOWN), fetchInstance(t, handler, "/eureka/apps/FOO/localhost:foo:9001").Status)
+}
+
+func TestInvalidStatusOverride(t *testing.T) {
+	upstream := &countingUpstream{status: http.StatusOK}
+	handler := RequestHandler(nil, false, time.Minute, upstream)
+
+	serve(handler, http.MethodPost, "/eureka/apps/FOO", registration("FOO", "localhost:foo:9001", 9001))
+
+	assert.Equal(t, http.StatusBadRequest, serve(handler, http.MethodPut, "/eureka/apps/FOO/localhost:foo:9001/status", ""))
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodPut, "/eureka/apps/FOO/localhost:foo:9002/status?value=DOWN", ""))
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodDelete, "/eureka/apps/FOO/localhost:foo:9002/status", ""))
+
+	// the status updates of the instances that are not registered locally never reach the remote eureka
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodPut, "/eureka/apps/BAR/remote:bar:8080/status?value=OUT_OF_SERVICE", ""))
+	assert.Equal(t, 0, upstream.count())
+	assert.Equal(t, eureka2.Status(eureka2.UP), fetchInstance(t, handler, "/eureka/apps/FOO/localhost:foo:9001").Status)
+}
+
+func TestStatusOverrideWithPollution(t *testing.T) {
+	upstream := &countingUpstream{status: http.StatusOK}
+	handler := RequestHandler(nil, true, time.Minute, upstream)
+
+	serve(handler, http.MethodPut, "/eureka/apps/BAR/remote:bar:8080/status?value=OUT_OF_SERVICE", "")
+
+	assert.Equal(t, 1, upstream.count())
+}
+
+func TestAppsWithSharedPrefix(t *testing.T) {
+	handler := RequestHandler(nil, false, time.Minute, emptyUpstream())
+
+	assert.Equal(t, http.StatusNoContent, serve(handler, http.MethodPost, "/eureka/apps/FOO", registration("FOO", "localhost:foo:9001", 9001)))
+	assert.Equal(t, http.StatusNoContent, serve(handler, http.MethodPost, "/eureka/apps/FOOBAR", registration("FOOBAR", "localhost:foobar:9002", 9002)))
+
+	// the fakes are looked up in random order, every heartbeat must reach its own application
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, http.StatusOK, serve(handler, http.MethodPut, "/eureka/apps/FOOBAR/localhost:foobar:9002", ""))
+		assert.Equal(t, http.StatusOK, serve(handler, http.MethodPut, "/eureka/apps/FOO/localhost:foo:9001", ""))
+		assert.Equal(t, http.StatusOK, serve(handler, http.MethodGet, "/eureka/apps/FOOBAR/localhost:foobar:9002", ""))
+	}
+
+	assert.Equal(t, http.StatusNoContent, serve(handler, http.MethodPost, "/eureka/apps/FOOBAR", registration("FOOBAR", "localhost:foobar:9003", 9003)))
+
+	apps := fetchApps(t, handler, "/eureka/apps")
+
+	assert.Equal(t, []string{"localhost:foo:9001"}, instanceIDs(apps, "FOO"))
+	assert.ElementsMatch(t, []string{"localhost:foobar:9002", "localhost:foobar:9003"}, instanceIDs(apps, "FOOBAR"))
+}
+
+func TestDeregistrationWithUpperCaseAppID(t *testing.T) {
+	handler := RequestHandler([]*Application{SingleInstanceApp("foo-service", "foo-service:1", "127.0.0.1", "localhost", 8080)}, false, time.Minute, emptyUpstream())
+
+	serve(handler, http.MethodPost, "/eureka/apps/FOO-SERVICE", registration("FOO-SERVICE", "localhost:foo-service:9001", 9001))
+
+	// the eureka clients send the application id in upper case
+	serve(handler, http.MethodDelete, "/eureka/apps/FOO-SERVICE/localhost:foo-service:9001", "")
+
+	assert.Equal(t, []string{"localhost:foo-service:8080"}, instanceIDs(fetchApps(t, handler, "/eureka/apps"), "FOO-SERVICE"))
+	assert.Equal(t, map[string]string{"localhost:foo-service:9001": eureka2.DELETED}, actions(fetchApps(t, handler, "/eureka/apps/delta"), "FOO-SERVICE"))
+}
+
+func fetchInstance(t *testing.T, handler http.Handler, path string) *eureka2.Instance {
+	rec := lookup(handler, path, "")
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	res := &eureka2.InstanceResponse{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), res), rec.Body.String())
+
+	return res.Instance
+}
@@ -0,0 +1,192 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+	"github.com/newestuser/eureka-proxy/lib/httputil"
+)
+
+// The path of the page showing the registry as the eureka clients see it.
+const DashboardPath = "/_proxy/dashboard"
+
+// The origin of the instances that are not faked.
+const upstreamOrigin = "upstream"
+
+// A single instance of the registry shown on the dashboard.
+type dashboardRow struct {
+	App           string
+	InstanceID    string
+	Address       string
+	Status        eureka2.Status
+	Origin        string
+	LastHeartbeat string
+	Source        string
+}
+
+type dashboard struct {
+	Rows  []*dashboardRow
+	Error string
+	Time  string
+}
+
+func (st *state) isDashboardRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+
+	return path == "/_proxy" || path == DashboardPath
+}
+
+// Serve the page with the merged registry where each instance is marked with the place it came from.
+func (st *state) serveDashboard(w http.ResponseWriter, r *http.Request) {
+
+	apps, err := st.mergedRegistry(r)
+
+	st.mu.Lock()
+	page := st.newDashboard(apps)
+	st.mu.Unlock()
+
+	if err != nil {
+		log.Printf("The dashboard will show only the fakes err: %s\n", err.Error())
+		page.Error = fmt.Sprintf("Could not fetch the registry, only the fakes are shown: %s", err.Error())
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := dashboardTemplate.Execute(w, page); err != nil {
+		log.Printf("Could not render the dashboard err: %s\n", err.Error())
+	}
+}
+
+// Fetch the registry the same way the eureka clients do, without logging the fakes on every refresh of the page.
+func (st *state) mergedRegistry(dashboardReq *http.Request) (*eureka2.Applications, error) {
+	r := dashboardReq.Clone(dashboardReq.Context())
+	r.URL.Path = "/eureka/apps"
+	r.URL.RawPath = ""
+	r.URL.RawQuery = ""
+	r.Header.Set("Accept", "application/json")
+	r.Header.Del("Accept-Encoding")
+
+	rec := httputil.DetachedRecorder()
+
+	st.rewriteRegistry(rec, r, st.mergeFullRegistry)
+
+	if rec.Status() != http.StatusOK {
+		return nil, fmt.Errorf("the registry responded with status: %d", rec.Status())
+	}
+
+	body, err := rec.Body()
+
+	if err != nil {
+		return nil, err
+	}
+
+	registry := &eureka2.State{}
+
+	if err := json.Unmarshal(body, registry); err != nil {
+		return nil, fmt.Errorf("could not parse the registry err: %s", err.Error())
+	}
+
+	if registry.Apps == nil {
+		return nil, fmt.Errorf("the registry has no applications")
+	}
+
+	return registry.Apps, nil
+}
+
+func (st *state) newDashboard(apps *eureka2.Applications) *dashboard {
+
+	if apps == nil {
+		apps = &eureka2.Applications{}
+		st.mergeFakes(apps)
+	}
+
+	rows := make([]*dashboardRow, 0)
+
+	for _, app := range apps.Applications {
+		clust := st.findCluster(app.Name)
+
+		for _, instance := range app.Instances {
+			row := &dashboardRow{App: app.Name, InstanceID: instance.InstanceID, Status: instance.Status, Origin: upstreamOrigin}
+
+			if instance.Port != nil {
+				row.Address = fmt.Sprintf("%s:%d", instance.IPAddress, instance.Port.Number)
+			} else {
+				row.Address = instance.IPAddress
+			}
+
+			if clust != nil {
+				if target := clust.findTarget(instance.InstanceID); target != nil {
+					row.Origin = target.Origin()
+					row.Source = target.source
+
+					if !target.lastRenewal.IsZero() {
+						row.LastHeartbeat = fmt.Sprintf("%s ago", st.now().Sub(target.lastRenewal).Round(time.Second))
+					}
+				}
+			}
+
+			rows = append(rows, row)
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].App != rows[j].App {
+			return rows[i].App < rows[j].App
+		}
+
+		return rows[i].InstanceID < rows[j].InstanceID
+	})
+
+	return &dashboard{Rows: rows, Time: st.now().Format(time.RFC1123)}
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta http-equiv="refresh" content="10">
+  <title>eureka-proxy</title>
+  <style>
+    body { font-family: sans-serif; margin: 2em; }
+    table { border-collapse: collapse; width: 100%; }
+    th, td { text-align: left; padding: 0.4em 0.8em; border-bottom: 1px solid #ddd; }
+    .error { color: #b00020; }
+    .origin { font-weight: bold; }
+    .upstream { color: #777; }
+    .static { color: #1565c0; }
+    .detected { color: #2e7d32; }
+    .admin { color: #ef6c00; }
+  </style>
+</head>
+<body>
+  <h1>eureka-proxy</h1>
+  <p>The registry as the eureka clients see it on {{.Time}}</p>
+  {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+  <table>
+    <tr><th>Application</th><th>Instance</th><th>Address</th><th>Status</th><th>Origin</th><th>Last heartbeat</th><th>Source</th></tr>
+    {{range .Rows}}
+    <tr>
+      <td>{{.App}}</td>
+      <td>{{.InstanceID}}</td>
+      <td>{{.Address}}</td>
+      <td>{{.Status}}</td>
+      <td class="origin {{.Origin}}">{{.Origin}}</td>
+      <td>{{.LastHeartbeat}}</td>
+      <td>{{.Source}}</td>
+    </tr>
+    {{end}}
+  </table>
+</body>
+</html>
+`))
@@ -0,0 +1,94 @@
+package fake
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDashboardRows(t *testing.T) {
+	remote := newRemoteRegistry(remoteInstance("FOO", 8080, eureka2.UP), remoteInstance("BAR", 8080, eureka2.DOWN))
+	st, clock := dashboardRegistry(remote)
+
+	serve(st, http.MethodPost, "/eureka/apps/BAZ", registration("BAZ", "localhost:baz:9001", 9001))
+	admin(st, http.MethodPost, "/_proxy/api/apps", `{"id": "QUX", "instances": [{"host": "my-laptop", "ip": "10.0.0.5", "port": 9002}]}`)
+	clock.add(30 * time.Second)
+
+	page := dashboardOf(t, st)
+
+	assert.Empty(t, page.Error)
+	assert.Equal(t, []*dashboardRow{
+		{App: "BAR", InstanceID: "remote:bar:8080", Address: "10.0.0.1:8080", Status: eureka2.DOWN, Origin: "upstream"},
+		{App: "BAZ", InstanceID: "localhost:baz:9001", Address: "127.0.0.1:9001", Status: eureka2.UP, Origin: "detected",
+			LastHeartbeat: "30s ago", Source: "registration from 192.0.2.1:1234"},
+		{App: "FOO", InstanceID: "localhost:foo:8080", Address: "127.0.0.1:8080", Status: eureka2.UP, Origin: "static"},
+		{App: "QUX", InstanceID: "my-laptop:qux:9002", Address: "10.0.0.5:9002", Status: eureka2.UP, Origin: "admin"},
+	}, page.Rows)
+}
+
+func TestDashboardWithUnavailableRegistry(t *testing.T) {
+	st, _ := dashboardRegistry(&countingUpstream{status: http.StatusBadGateway})
+
+	serve(st, http.MethodPost, "/eureka/apps/BAZ", registration("BAZ", "localhost:baz:9001", 9001))
+
+	page := dashboardOf(t, st)
+
+	assert.Contains(t, page.Error, "status: 502")
+	assert.Len(t, page.Rows, 2)
+	assert.Equal(t, "detected", page.Rows[0].Origin)
+	assert.Equal(t, "static", page.Rows[1].Origin)
+
+	rec := httptest.NewRecorder()
+	st.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, DashboardPath, nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Could not fetch the registry, only the fakes are shown")
+	assert.Contains(t, rec.Body.String(), "localhost:baz:9001")
+}
+
+func TestDashboardRefreshDoesNotLogFakes(t *testing.T) {
+	st, _ := dashboardRegistry(emptyUpstream())
+
+	out := &bytes.Buffer{}
+	log.SetOutput(out)
+	defer log.SetOutput(os.Stderr)
+
+	rec := httptest.NewRecorder()
+	st.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, DashboardPath, nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "localhost:foo:8080")
+	assert.NotContains(t, out.String(), "Will respond with the following fake services")
+}
+
+// A registry with a static fake FOO that uses a clock which only moves when it is told to.
+func dashboardRegistry(upstream http.Handler) (*state, *testClock) {
+	clock := &testClock{t: time.Now()}
+
+	st := RequestHandler([]*Application{SingleInstanceApp("foo", "foo:1", "127.0.0.1", "localhost", 8080)}, false, time.Minute, upstream).(*state)
+	st.now = clock.now
+
+	return st, clock
+}
+
+// The dashboard the page is rendered from, it falls back to the fakes when the registry could not be fetched.
+func dashboardOf(t *testing.T, st *state) *dashboard {
+	apps, err := st.mergedRegistry(httptest.NewRequest(http.MethodGet, DashboardPath, nil))
+
+	st.mu.Lock()
+	page := st.newDashboard(apps)
+	st.mu.Unlock()
+
+	if err != nil {
+		page.Error = err.Error()
+	}
+
+	return page
+}
@@ -0,0 +1,224 @@
+package fake
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+)
+
+// The time for which a change of a fake instance is reported in the registry delta.
+// Matches the default retention of the recently changed queue of the eureka server.
+const deltaRetention = 3 * time.Minute
+
+// A single change of a fake instance that should be reported to clients fetching the registry delta.
+type change struct {
+	action string
+	appID  string
+	target *Target
+	at     time.Time
+}
+
+// The recently changed fake instances
+type changeLog struct {
+	changes []*change
+	now     func() time.Time
+}
+
+func newChangeLog() *changeLog {
+	return &changeLog{now: time.Now}
+}
+
+// Record a change of a fake instance.
+func (l *changeLog) record(action, appID string, target *Target) {
+	l.prune()
+
+	l.changes = append(l.changes, &change{action: action, appID: appID, target: target, at: l.now()})
+}
+
+// Retrieve the changes that are still within the retention period, oldest first.
+func (l *changeLog) recent() []*change {
+	l.prune()
+
+	return l.changes
+}
+
+func (l *changeLog) prune() {
+	threshold := l.now().Add(-deltaRetention)
+
+	i := 0
+	for i < len(l.changes) && l.changes[i].at.Before(threshold) {
+		i++
+	}
+
+	l.changes = l.changes[i:]
+}
+
+// Add the recent changes of the fake instances to the delta.
+// When an instance changed multiple times only the latest change is reported.
+func (l *changeLog) applyTo(delta registryApps) {
+
+	latest := make(map[string]*change)
+	instances := make(map[string]*eureka2.Instance)
+	order := make([]string, 0)
+
+	for _, c := range l.recent() {
+		instance := (&Application{ID: c.appID}).NewInstance(c.target)
+		instance.ActionType = c.action
+
+		// the clients identify the instances by the id they are reported with
+		key := strings.ToLower(c.appID + "/" + instance.InstanceID)
+
+		if _, ok := latest[key]; !ok {
+			order = append(order, key)
+		}
+
+		latest[key] = c
+		instances[key] = instance
+	}
+
+	for _, key := range order {
+		c := latest[key]
+
+		app := &Application{ID: c.appID}
+		instance := instances[key]
+
+		if exists, existingApp := delta.ContainsApp(c.appID); exists {
+			existingApp.Instances = append(existingApp.Instances, instance)
+		} else {
+			eurekaApp := app.NewEurekaApp()
+			eurekaApp.Instances = []*eureka2.Instance{instance}
+			delta.AddApp(eurekaApp)
+		}
+	}
+}
+
+// The statuses of the instances in the remote registry, keyed by the lower case application id and the instance id.
+// They are taken from the last full registry and kept up to date with the deltas, so that the hash code of a delta
+// can be adjusted for the fakes without fetching the full registry.
+type remoteStatuses struct {
+	known bool
+	apps  map[string]map[string]string
+}
+
+// Replace the statuses with the ones of the full registry.
+func (s *remoteStatuses) reset(apps *eureka2.Applications) {
+	s.known = true
+	s.apps = make(map[string]map[string]string)
+
+	for _, app := range apps.Applications {
+		for _, instance := range app.Instances {
+			s.set(app.Name, instance)
+		}
+	}
+}
+
+// Apply the changes of the delta, applying the same delta again changes nothing.
+func (s *remoteStatuses) apply(delta *eureka2.Applications) {
+	if !s.known {
+		return
+	}
+
+	for _, app := range delta.Applications {
+		for _, instance := range app.Instances {
+			if instance.ActionType == eureka2.DELETED {
+				delete(s.apps[strings.ToLower(app.Name)], instance.InstanceID)
+			} else {
+				s.set(app.Name, instance)
+			}
+		}
+	}
+}
+
+func (s *remoteStatuses) set(appID string, instance *eureka2.Instance) {
+	appID = strings.ToLower(appID)
+
+	if s.apps[appID] == nil {
+		s.apps[appID] = make(map[string]string)
+	}
+
+	s.apps[appID][instance.InstanceID] = string(instance.Status)
+}
+
+// The statuses of the remote instances of the application.
+func (s *remoteStatuses) of(appID string) []string {
+	statuses := make([]string, 0)
+
+	for _, status := range s.apps[strings.ToLower(appID)] {
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// Adjust the hash code of a registry for instances that are removed from it and added to it,
+// the hash code has the format of ReconcileHashCode, example: DOWN_1_UP_3_
+func adjustHashCode(hashCode string, removed, added []string) (string, error) {
+	counts := make(map[string]int)
+	status := make([]string, 0)
+
+	for _, token := range strings.Split(strings.TrimSuffix(hashCode, "_"), "_") {
+		if token == "" {
+			continue
+		}
+
+		count, err := strconv.Atoi(token)
+
+		// the statuses can contain underscores, example: OUT_OF_SERVICE_1_
+		if err != nil {
+			status = append(status, token)
+			continue
+		}
+
+		if len(status) == 0 {
+			return "", fmt.Errorf("the hash code '%s' has a count without a status", hashCode)
+		}
+
+		counts[strings.Join(status, "_")] += count
+		status = status[:0]
+	}
+
+	if len(status) > 0 {
+		return "", fmt.Errorf("the hash code '%s' has a status without a count", hashCode)
+	}
+
+	for _, s := range removed {
+		counts[s]--
+	}
+
+	for _, s := range added {
+		counts[s]++
+	}
+
+	for s, count := range counts {
+		if count < 0 {
+			return "", fmt.Errorf("the hash code '%s' has fewer %s instances than the ones removed", hashCode, s)
+		}
+	}
+
+	return formatHashCode(counts), nil
+}
+
+// Format the count of instances per status the way ReconcileHashCode does, the statuses without instances are left out.
+func formatHashCode(counts map[string]int) string {
+	statuses := make([]string, 0, len(counts))
+
+	for status, count := range counts {
+		if count > 0 {
+			statuses = append(statuses, status)
+		}
+	}
+
+	sort.Strings(statuses)
+
+	buff := &bytes.Buffer{}
+	for _, status := range statuses {
+		buff.WriteString(fmt.Sprintf("%s_%d_", status, counts[status]))
+	}
+
+	return buff.String()
+}
@@ -0,0 +1,201 @@
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeltaReportsChangesOfFakes(t *testing.T) {
+	remote := newRemoteRegistry(remoteInstance("FOO", 8080, eureka2.UP), remoteInstance("BAR", 8080, eureka2.UP))
+	remote.delta.AddApp(
+		&eureka2.Application{Name: "FOO", Instances: []*eureka2.Instance{modified(remoteInstance("FOO", 8080, eureka2.UP))}},
+		&eureka2.Application{Name: "BAR", Instances: []*eureka2.Instance{modified(remoteInstance("BAR", 8080, eureka2.UP))}},
+	)
+
+	handler := RequestHandler([]*Application{SingleInstanceApp("foo", "foo:1", "127.0.0.1", "localhost", 9000)}, false, 0, remote)
+
+	serve(handler, http.MethodPost, "/eureka/apps/BAZ", registration("BAZ", "localhost:baz:9001", 9001))
+	serve(handler, http.MethodPost, "/eureka/apps/BAZ", registration("BAZ", "localhost:baz:9002", 9002))
+	serve(handler, http.MethodPut, "/eureka/apps/BAZ/localhost:baz:9002/status?value=OUT_OF_SERVICE", "")
+	serve(handler, http.MethodPost, "/eureka/apps/BAZ", registration("BAZ", "localhost:baz:9003", 9003))
+	serve(handler, http.MethodDelete, "/eureka/apps/BAZ/localhost:baz:9003", "")
+
+	delta := fetchApps(t, handler, "/eureka/apps/delta")
+
+	// the remote changes of the fake applications are left out
+	exists, _ := delta.ContainsApp("FOO")
+	assert.False(t, exists)
+	assert.Equal(t, map[string]string{"remote:bar:8080": eureka2.MODIFIED}, actions(delta, "BAR"))
+
+	// only the latest change of every fake instance is reported
+	assert.Equal(t, map[string]string{
+		"localhost:baz:9001": eureka2.ADDED,
+		"localhost:baz:9002": eureka2.MODIFIED,
+		"localhost:baz:9003": eureka2.DELETED,
+	}, actions(delta, "BAZ"))
+
+	full := fetchApps(t, handler, "/eureka/apps")
+
+	assert.Equal(t, full.ReconcileHashCode(), full.HashCode)
+	assert.Equal(t, full.HashCode, delta.HashCode)
+}
+
+func TestDeltaHashCodeWithoutFullFetch(t *testing.T) {
+	remote := newRemoteRegistry(remoteInstance("FOO", 8080, eureka2.UP), remoteInstance("FOO", 8081, eureka2.DOWN), remoteInstance("BAR", 8080, eureka2.UP))
+
+	handler := RequestHandler([]*Application{SingleInstanceApp("foo", "foo:1", "127.0.0.1", "localhost", 9000)}, false, 0, remote)
+
+	// a client that polls the delta before any client fetched the full registry
+	serve(handler, http.MethodGet, "/eureka/apps/delta", "")
+	assert.Equal(t, 1, remote.fullFetches())
+
+	full := fetchApps(t, handler, "/eureka/apps")
+	assert.Equal(t, 2, remote.fullFetches())
+
+	serve(handler, http.MethodPost, "/eureka/apps/BAZ", registration("BAZ", "localhost:baz:9001", 9001))
+
+	for i := 0; i < 3; i++ {
+		delta := fetchApps(t, handler, "/eureka/apps/delta")
+
+		assert.Equal(t, "UP_3_", delta.HashCode)
+	}
+
+	assert.Equal(t, 2, remote.fullFetches())
+	assert.Equal(t, "UP_2_", full.HashCode)
+	assert.Equal(t, "UP_3_", fetchApps(t, handler, "/eureka/apps").HashCode)
+}
+
+func TestDeltaReportsReregistrations(t *testing.T) {
+	handler := RequestHandler(nil, false, time.Minute, emptyUpstream())
+
+	withVersion := func(version string) string {
+		return `{"instance":{"instanceId":"localhost:foo:9001","app":"FOO","hostName":"localhost","ipAddr":"127.0.0.1","status":"UP",` +
+			`"port":{"$":9001,"@enabled":"true"},"vipAddress":"foo","metadata":{"version":"` + version + `"}}}`
+	}
+
+	serve(handler, http.MethodPost, "/eureka/apps/FOO", withVersion("1.0.0"))
+	full := fetchApps(t, handler, "/eureka/apps")
+
+	// only the metadata changes, the hash code stays the same so the clients rely on the delta
+	serve(handler, http.MethodPost, "/eureka/apps/FOO", withVersion("1.1.0"))
+	delta := fetchApps(t, handler, "/eureka/apps/delta")
+
+	assert.Equal(t, map[string]string{"localhost:foo:9001": eureka2.MODIFIED}, actions(delta, "FOO"))
+	assert.Equal(t, full.HashCode, delta.HashCode)
+
+	_, app := delta.ContainsApp("FOO")
+	assert.Equal(t, "1.1.0", app.Instances[0].MetaData.Get("version"))
+}
+
+func TestAdjustHashCode(t *testing.T) {
+	tests := []struct {
+		hashCode string
+		removed  []string
+		added    []string
+		expected string
+	}{
+		{"DOWN_1_UP_3_", []string{"UP"}, []string{"OUT_OF_SERVICE"}, "DOWN_1_OUT_OF_SERVICE_1_UP_2_"},
+		{"OUT_OF_SERVICE_2_UP_1_", []string{"OUT_OF_SERVICE", "OUT_OF_SERVICE"}, nil, "UP_1_"},
+		{"", nil, []string{"UP", "UP"}, "UP_2_"},
+		{"UP_1_", []string{"UP"}, nil, ""},
+	}
+
+	for _, test := range tests {
+		hashCode, err := adjustHashCode(test.hashCode, test.removed, test.added)
+
+		assert.Nil(t, err, test.hashCode)
+		assert.Equal(t, test.expected, hashCode, test.hashCode)
+	}
+
+	for _, invalid := range []string{"UP_", "1_UP_", "UP_1_x"} {
+		_, err := adjustHashCode(invalid, nil, nil)
+
+		assert.NotNil(t, err, invalid)
+	}
+
+	_, err := adjustHashCode("UP_1_", []string{"DOWN"}, nil)
+	assert.NotNil(t, err)
+}
+
+// A remote eureka that serves the registry and the delta, it counts the requests for the full registry.
+type remoteRegistry struct {
+	full  *eureka2.Applications
+	delta *eureka2.Applications
+
+	mu    sync.Mutex
+	fulls int
+}
+
+func newRemoteRegistry(instances ...*eureka2.Instance) *remoteRegistry {
+	full := &eureka2.Applications{Applications: make([]*eureka2.Application, 0)}
+
+	for _, instance := range instances {
+		if exists, app := full.ContainsApp(instance.App); exists {
+			app.Instances = append(app.Instances, instance)
+		} else {
+			full.AddApp(&eureka2.Application{Name: instance.App, Instances: []*eureka2.Instance{instance}})
+		}
+	}
+
+	full.HashCode = full.ReconcileHashCode()
+
+	// the hash code of the delta is the one of the full registry after the delta is applied
+	return &remoteRegistry{full: full, delta: &eureka2.Applications{Applications: make([]*eureka2.Application, 0), HashCode: full.HashCode}}
+}
+
+func (u *remoteRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	apps := u.delta
+
+	if !strings.HasSuffix(r.URL.Path, "/delta") {
+		u.mu.Lock()
+		u.fulls++
+		u.mu.Unlock()
+
+		apps = u.full
+	}
+
+	body, _ := json.Marshal(&eureka2.State{Apps: apps})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func (u *remoteRegistry) fullFetches() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.fulls
+}
+
+func remoteInstance(appID string, port int, status eureka2.Status) *eureka2.Instance {
+	instance := eureka2.NewInstance(appID, "10.0.0.1", "remote", port)
+	instance.Status = status
+
+	return instance
+}
+
+func modified(instance *eureka2.Instance) *eureka2.Instance {
+	instance.ActionType = eureka2.MODIFIED
+
+	return instance
+}
+
+// The action of every instance of the application in the delta, keyed by the instance id.
+func actions(delta *eureka2.Applications, appID string) map[string]string {
+	actions := make(map[string]string)
+
+	if exists, app := delta.ContainsApp(appID); exists {
+		for _, instance := range app.Instances {
+			actions[instance.InstanceID] = instance.ActionType
+		}
+	}
+
+	return actions
+}
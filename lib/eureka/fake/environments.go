@@ -0,0 +1,440 @@
+package fake
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+	"github.com/newestuser/eureka-proxy/lib/httputil"
+)
+
+// example: /eureka/apps/{appID} or /eureka/apps/{appID}/{instanceID}
+var appPathPattern = regexp.MustCompile(`/eureka/apps/([^/]+)`)
+
+// A remote eureka whose registry is merged with the registries of the other environments.
+type Environment struct {
+	Name    string
+	Handler http.Handler
+}
+
+// A handler that merges the registries of multiple environments into one, it is used in place of a single remote eureka.
+// Every application is taken from the first environment in its preference order that has it, the applications without
+// a preference are taken from the first environment in the configured order that has them.
+// The requests for a single application are sent to the first environment in its preference order,
+// the rest of the requests are sent to the first environment.
+type Environments struct {
+	mu           sync.RWMutex
+	environments []*Environment
+	// the names of the environments in order of preference, keyed by the lower case application id
+	precedence map[string][]string
+
+	// the instances of the registries of the environments keyed by the name of the environment,
+	// they are kept up to date with the deltas so that the merged delta does not need the full registries
+	statusMu sync.Mutex
+	statuses map[string]*remoteStatuses
+}
+
+// Create a handler that merges the registries of the environments, the precedence lists the preferred environments of the applications.
+func MergeEnvironments(environments []*Environment, precedence map[string][]string) (*Environments, error) {
+	envs := &Environments{}
+
+	if err := envs.Reload(environments, precedence); err != nil {
+		return nil, err
+	}
+
+	return envs, nil
+}
+
+// Replace the environments and the precedence, the requests that are in progress complete with the old ones.
+func (envs *Environments) Reload(environments []*Environment, precedence map[string][]string) error {
+
+	if len(environments) == 0 {
+		return fmt.Errorf("at least one environment is required")
+	}
+
+	names := make(map[string]bool)
+
+	for _, env := range environments {
+		if names[env.Name] {
+			return fmt.Errorf("the environment %s is configured more than once", env.Name)
+		}
+
+		names[env.Name] = true
+	}
+
+	normalized := make(map[string][]string)
+
+	for appID, preferred := range precedence {
+		for _, name := range preferred {
+			if !names[name] {
+				return fmt.Errorf("unknown environment %s in the precedence of %s", name, appID)
+			}
+		}
+
+		normalized[strings.ToLower(appID)] = preferred
+	}
+
+	envs.mu.Lock()
+	envs.environments = environments
+	envs.precedence = normalized
+	envs.mu.Unlock()
+
+	envs.statusMu.Lock()
+	envs.statuses = make(map[string]*remoteStatuses)
+	envs.statusMu.Unlock()
+
+	return nil
+}
+
+// The instances of the registry of the environment, the statuses must be locked.
+func (envs *Environments) statusesOf(env *Environment) *remoteStatuses {
+	statuses := envs.statuses[env.Name]
+
+	if statuses == nil {
+		statuses = &remoteStatuses{}
+		envs.statuses[env.Name] = statuses
+	}
+
+	return statuses
+}
+
+func (envs *Environments) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	envs.mu.RLock()
+	environments, precedence := envs.environments, envs.precedence
+	envs.mu.RUnlock()
+
+	if len(environments) == 1 {
+		environments[0].Handler.ServeHTTP(w, r)
+		return
+	}
+
+	m := &merge{environments: environments, precedence: precedence, envs: envs}
+
+	if r.Method == http.MethodGet && (strings.HasSuffix(strings.TrimSuffix(r.URL.Path, "/"), "eureka/apps") || vipLookupPattern.MatchString(r.URL.Path)) {
+
+		m.respondWithMerged(w, r)
+		return
+	}
+
+	if r.Method == http.MethodGet && strings.HasSuffix(strings.TrimSuffix(r.URL.Path, "/"), "eureka/apps/delta") {
+
+		m.respondWithMergedDelta(w, r)
+		return
+	}
+
+	if matches := appPathPattern.FindStringSubmatch(r.URL.Path); matches != nil {
+
+		m.ordered(matches[1])[0].Handler.ServeHTTP(w, r)
+		return
+	}
+
+	environments[0].Handler.ServeHTTP(w, r)
+}
+
+// A single merge of the registries using the environments that were configured when the request arrived.
+type merge struct {
+	environments []*Environment
+	precedence   map[string][]string
+	envs         *Environments
+}
+
+// The environments in order of preference of the application.
+func (m *merge) ordered(appID string) []*Environment {
+	ordered := make([]*Environment, 0, len(m.environments))
+	added := make(map[string]bool)
+
+	for _, name := range m.precedence[strings.ToLower(appID)] {
+		for _, env := range m.environments {
+			if env.Name == name && !added[name] {
+				ordered = append(ordered, env)
+				added[name] = true
+			}
+		}
+	}
+
+	for _, env := range m.environments {
+		if !added[env.Name] {
+			ordered = append(ordered, env)
+		}
+	}
+
+	return ordered
+}
+
+// The index of the environment the application is taken from, -1 when none of the environments has the application.
+func (m *merge) sourceOf(appID string, has func(i int, appID string) bool) int {
+	for _, env := range m.ordered(appID) {
+		i := m.indexOf(env)
+
+		if has(i, appID) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Check the presence of the applications in the registries of the environments.
+func inRegistries(registries []*registry) func(i int, appID string) bool {
+	return func(i int, appID string) bool {
+		if registries[i] == nil {
+			return false
+		}
+
+		exists, _ := registries[i].apps.ContainsApp(appID)
+
+		return exists
+	}
+}
+
+// Check the presence of the applications in the instances of the environments, the statuses must be locked.
+func (m *merge) inStatuses(i int, appID string) bool {
+	statuses := m.envs.statusesOf(m.environments[i])
+
+	return statuses.known && len(statuses.apps[strings.ToLower(appID)]) > 0
+}
+
+func (m *merge) indexOf(env *Environment) int {
+	for i, e := range m.environments {
+		if e == env {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Respond with the registry where every application is taken from its preferred environment.
+func (m *merge) respondWithMerged(w http.ResponseWriter, r *http.Request) {
+
+	recs, registries := m.fetchAll(r)
+	base := firstAvailable(registries)
+
+	if base < 0 {
+		recs[0].CopyTo(w)
+		return
+	}
+
+	if strings.HasSuffix(strings.TrimSuffix(r.URL.Path, "/"), "eureka/apps") {
+		m.resetStatuses(m.environments, registries)
+	}
+
+	merged := m.mergeInto(base, registries, inRegistries(registries))
+	merged.setHashCode(merged.ReconcileHashCode())
+
+	m.respond(w, recs[base], merged, r)
+}
+
+// Respond with the delta where the changes of every application are taken from its preferred environment.
+// The instances of the environments decide which environment the applications are taken from, the full registry of
+// an environment is only fetched while its instances are unknown, for example until a client fetched the full registry.
+func (m *merge) respondWithMergedDelta(w http.ResponseWriter, r *http.Request) {
+
+	if unknown := m.unknownStatuses(); len(unknown) > 0 {
+		fullReq := r.Clone(r.Context())
+		fullReq.URL.Path = strings.TrimSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/delta")
+		fullReq.URL.RawPath = ""
+
+		_, full := (&merge{environments: unknown, precedence: m.precedence, envs: m.envs}).fetchAll(fullReq)
+		m.resetStatuses(unknown, full)
+	}
+
+	recs, deltas := m.fetchAll(r)
+
+	base := firstAvailable(deltas)
+
+	if base < 0 {
+		recs[0].CopyTo(w)
+		return
+	}
+
+	m.envs.statusMu.Lock()
+
+	for i, env := range m.environments {
+		statuses := m.envs.statusesOf(env)
+
+		if deltas[i] == nil {
+			// the changes of the environment are missed, its full registry is fetched with the next delta
+			statuses.known = false
+		} else {
+			statuses.apply(deltas[i].apps)
+		}
+	}
+
+	merged := m.mergeInto(base, deltas, m.inStatuses)
+
+	// the clients compare the hash code with the one of their merged registry
+	merged.setHashCode(m.mergedHashCode())
+
+	m.envs.statusMu.Unlock()
+
+	m.respond(w, recs[base], merged, r)
+}
+
+// The environments whose instances are unknown.
+func (m *merge) unknownStatuses() []*Environment {
+	m.envs.statusMu.Lock()
+	defer m.envs.statusMu.Unlock()
+
+	unknown := make([]*Environment, 0)
+
+	for _, env := range m.environments {
+		if !m.envs.statusesOf(env).known {
+			unknown = append(unknown, env)
+		}
+	}
+
+	return unknown
+}
+
+// Replace the instances of the environments with the ones of their full registries, before the registries are merged.
+func (m *merge) resetStatuses(environments []*Environment, registries []*registry) {
+	m.envs.statusMu.Lock()
+	defer m.envs.statusMu.Unlock()
+
+	for i, env := range environments {
+		if registries[i] != nil {
+			m.envs.statusesOf(env).reset(registries[i].apps)
+		}
+	}
+}
+
+// The hash code of the merged full registry computed from the instances of the environments, the statuses must be locked.
+func (m *merge) mergedHashCode() string {
+	counts := make(map[string]int)
+	counted := make(map[string]bool)
+
+	for _, env := range m.environments {
+		for appID := range m.envs.statusesOf(env).apps {
+			if counted[appID] {
+				continue
+			}
+
+			counted[appID] = true
+
+			if source := m.sourceOf(appID, m.inStatuses); source >= 0 {
+				for _, status := range m.envs.statusesOf(m.environments[source]).of(appID) {
+					counts[status]++
+				}
+			}
+		}
+	}
+
+	return formatHashCode(counts)
+}
+
+// Replace the applications of the base registry with the ones from the registries of their preferred environments.
+// The sources decide the environment the applications are taken from.
+func (m *merge) mergeInto(base int, registries []*registry, sources func(i int, appID string) bool) *registry {
+	merged := registries[base]
+
+	// the environment of every application is decided before the base registry is changed
+	taken := make(map[string]int)
+
+	for _, reg := range registries {
+		if reg == nil {
+			continue
+		}
+
+		for _, app := range reg.apps.Applications {
+			appID := strings.ToLower(app.Name)
+
+			if _, ok := taken[appID]; ok {
+				continue
+			}
+
+			source := m.sourceOf(app.Name, sources)
+
+			if source < 0 {
+				// the application is not in any of the full registries, it is taken from the registry it is in
+				source = m.sourceOf(app.Name, inRegistries(registries))
+			}
+
+			taken[appID] = source
+		}
+	}
+
+	for _, app := range append([]*eureka2.Application{}, merged.apps.Applications...) {
+		if taken[strings.ToLower(app.Name)] != base {
+			merged.RemoveApp(app.Name)
+		}
+	}
+
+	for i, reg := range registries {
+		if reg == nil || i == base {
+			continue
+		}
+
+		for _, app := range reg.apps.Applications {
+			if taken[strings.ToLower(app.Name)] == i {
+				merged.AddApp(app)
+			}
+		}
+	}
+
+	return merged
+}
+
+// Send the request to all the environments, the registries are nil for the environments that did not respond with one.
+func (m *merge) fetchAll(r *http.Request) ([]*httputil.HttpResponseRecorder, []*registry) {
+	recs := make([]*httputil.HttpResponseRecorder, len(m.environments))
+	registries := make([]*registry, len(m.environments))
+
+	wg := sync.WaitGroup{}
+
+	for i, env := range m.environments {
+		wg.Add(1)
+
+		go func(i int, env *Environment) {
+			defer wg.Done()
+
+			recs[i] = httputil.DetachedRecorder()
+			env.Handler.ServeHTTP(recs[i], r.Clone(r.Context()))
+
+			if recs[i].Status() != http.StatusOK {
+				log.Printf("The environment %s will be left out of the registry, status: %d\n", env.Name, recs[i].Status())
+				return
+			}
+
+			reg, err := deserialize(recs[i])
+
+			if err != nil {
+				log.Printf("The environment %s will be left out of the registry err: %s\n", env.Name, err.Error())
+				return
+			}
+
+			registries[i] = reg
+		}(i, env)
+	}
+
+	wg.Wait()
+
+	return recs, registries
+}
+
+// Write the merged registry the way the environment it is based on responded.
+func (m *merge) respond(w http.ResponseWriter, rec *httputil.HttpResponseRecorder, merged *registry, r *http.Request) {
+	appBytes, err := serialize(rec, merged)
+
+	if err != nil {
+		log.Printf("The registry of %s %s will be passed through without the other environments err: %s\n", r.Method, r.URL.Path, err.Error())
+		rec.CopyTo(w)
+		return
+	}
+
+	rec.CopyWith(w, appBytes)
+}
+
+func firstAvailable(registries []*registry) int {
+	for i, reg := range registries {
+		if reg != nil {
+			return i
+		}
+	}
+
+	return -1
+}
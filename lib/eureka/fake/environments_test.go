@@ -0,0 +1,160 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeEnvironments(t *testing.T) {
+	dev := environment("dev", "PAYMENTS", "USERS")
+	qa := environment("qa", "PAYMENTS", "ORDERS")
+
+	handler, err := MergeEnvironments([]*Environment{dev, qa}, map[string][]string{"payments": {"qa"}})
+	assert.Nil(t, err)
+
+	apps := fetchApps(t, handler, "/eureka/apps")
+
+	assert.Equal(t, "qa", envOf(apps, "PAYMENTS"))
+	assert.Equal(t, "dev", envOf(apps, "USERS"))
+	assert.Equal(t, "qa", envOf(apps, "ORDERS"))
+	assert.Len(t, apps.Applications, 3)
+	assert.Equal(t, apps.ReconcileHashCode(), apps.HashCode)
+}
+
+func TestMergeEnvironmentsDelta(t *testing.T) {
+	dev := environment("dev", "PAYMENTS", "USERS")
+	qa := environment("qa", "PAYMENTS", "ORDERS")
+
+	handler, err := MergeEnvironments([]*Environment{dev, qa}, map[string][]string{"payments": {"qa"}})
+	assert.Nil(t, err)
+
+	delta := fetchApps(t, handler, "/eureka/apps/delta")
+	full := fetchApps(t, handler, "/eureka/apps")
+
+	assert.Equal(t, "qa", envOf(delta, "PAYMENTS"))
+	assert.Equal(t, "dev", envOf(delta, "USERS"))
+	assert.Len(t, delta.Applications, 3)
+	assert.Equal(t, full.HashCode, delta.HashCode)
+}
+
+func TestMergeEnvironmentsDeltaWithoutFullFetches(t *testing.T) {
+	dev := newRemoteRegistry(remoteInstance("PAYMENTS", 8080, eureka2.UP), remoteInstance("USERS", 8080, eureka2.UP))
+	qa := newRemoteRegistry(remoteInstance("PAYMENTS", 8081, eureka2.UP), remoteInstance("ORDERS", 8081, eureka2.DOWN))
+
+	// both environments report a change of the payments, only the one of the preferred environment is taken
+	dev.delta.AddApp(&eureka2.Application{Name: "PAYMENTS", Instances: []*eureka2.Instance{modified(remoteInstance("PAYMENTS", 8080, eureka2.UP))}})
+	qa.delta.AddApp(&eureka2.Application{Name: "PAYMENTS", Instances: []*eureka2.Instance{modified(remoteInstance("PAYMENTS", 8081, eureka2.UP))}})
+
+	handler, err := MergeEnvironments([]*Environment{{Name: "dev", Handler: dev}, {Name: "qa", Handler: qa}}, map[string][]string{"payments": {"qa"}})
+	assert.Nil(t, err)
+
+	// the full registries are fetched once when a client polls the delta first
+	delta := fetchApps(t, handler, "/eureka/apps/delta")
+
+	assert.Equal(t, map[string]string{"remote:payments:8081": eureka2.MODIFIED}, actions(delta, "PAYMENTS"))
+	assert.Len(t, delta.Applications, 1)
+	assert.Equal(t, 1, dev.fullFetches())
+	assert.Equal(t, 1, qa.fullFetches())
+
+	full := fetchApps(t, handler, "/eureka/apps")
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, full.HashCode, fetchApps(t, handler, "/eureka/apps/delta").HashCode)
+	}
+
+	assert.Equal(t, "DOWN_1_UP_2_", full.HashCode)
+	assert.Equal(t, 2, dev.fullFetches())
+	assert.Equal(t, 2, qa.fullFetches())
+}
+
+func TestMergeEnvironmentsWithUnavailableEnvironment(t *testing.T) {
+	dev := environment("dev", "PAYMENTS", "USERS")
+	qa := &Environment{Name: "qa", Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})}
+
+	handler, err := MergeEnvironments([]*Environment{dev, qa}, map[string][]string{"payments": {"qa"}})
+	assert.Nil(t, err)
+
+	apps := fetchApps(t, handler, "/eureka/apps")
+
+	assert.Equal(t, "dev", envOf(apps, "PAYMENTS"))
+	assert.Equal(t, "dev", envOf(apps, "USERS"))
+}
+
+func TestMergeEnvironmentsWithUnknownPrecedence(t *testing.T) {
+	_, err := MergeEnvironments([]*Environment{environment("dev")}, map[string][]string{"payments": {"qa"}})
+
+	assert.NotNil(t, err)
+}
+
+func TestReloadEnvironments(t *testing.T) {
+	dev := environment("dev", "PAYMENTS", "USERS")
+	qa := environment("qa", "PAYMENTS", "ORDERS")
+
+	handler, err := MergeEnvironments([]*Environment{dev, qa}, map[string][]string{"payments": {"qa"}})
+	assert.Nil(t, err)
+
+	assert.Nil(t, handler.Reload([]*Environment{dev, qa}, map[string][]string{"payments": {"dev"}}))
+	assert.Equal(t, "dev", envOf(fetchApps(t, handler, "/eureka/apps"), "PAYMENTS"))
+
+	// the invalid configurations are rejected and the previous environments are still merged
+	assert.NotNil(t, handler.Reload([]*Environment{qa}, map[string][]string{"payments": {"dev"}}))
+	assert.NotNil(t, handler.Reload([]*Environment{dev, dev}, nil))
+	assert.NotNil(t, handler.Reload(nil, nil))
+
+	apps := fetchApps(t, handler, "/eureka/apps")
+
+	assert.Equal(t, "dev", envOf(apps, "PAYMENTS"))
+	assert.Equal(t, "dev", envOf(apps, "USERS"))
+	assert.Equal(t, "qa", envOf(apps, "ORDERS"))
+}
+
+// An environment that serves the applications with a single instance on a host named after the environment.
+func environment(name string, appIDs ...string) *Environment {
+	apps := &eureka2.Applications{Applications: make([]*eureka2.Application, 0)}
+
+	for _, appID := range appIDs {
+		instance := eureka2.NewInstance(appID, "127.0.0.1", name, 8080)
+		apps.AddApp(&eureka2.Application{Name: appID, Instances: []*eureka2.Instance{instance}})
+	}
+
+	apps.HashCode = apps.ReconcileHashCode()
+
+	return &Environment{Name: name, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := json.Marshal(&eureka2.State{Apps: apps})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})}
+}
+
+func fetchApps(t *testing.T, handler http.Handler, path string) *eureka2.Applications {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	state := &eureka2.State{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), state), rec.Body.String())
+
+	return state.Apps
+}
+
+func envOf(apps *eureka2.Applications, appID string) string {
+	exists, app := apps.ContainsApp(appID)
+
+	if !exists || len(app.Instances) != 1 {
+		return fmt.Sprintf("%s is missing", strings.ToLower(appID))
+	}
+
+	// the instance ids start with the name of the environment
+	return strings.Split(app.Instances[0].InstanceID, ":")[0]
+}
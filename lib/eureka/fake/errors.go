@@ -0,0 +1,41 @@
+package fake
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// An error that is reported to the client with the status code.
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// Create an error caused by a request that cannot be understood.
+func badRequest(format string, vals ...interface{}) error {
+	return &statusError{status: http.StatusBadRequest, msg: fmt.Sprintf(format, vals...)}
+}
+
+// Create an error caused by a request for a resource that does not exist.
+func notFound(format string, vals ...interface{}) error {
+	return &statusError{status: http.StatusNotFound, msg: fmt.Sprintf(format, vals...)}
+}
+
+// Respond with the status of the error, errors without a status are reported as internal server errors.
+func respondWithError(w http.ResponseWriter, r *http.Request, err error) {
+
+	status := http.StatusInternalServerError
+
+	if statusErr, ok := err.(*statusError); ok {
+		status = statusErr.status
+	}
+
+	log.Printf("Could not handle %s %s responding with %d err: %s\n", r.Method, r.URL.Path, status, err.Error())
+
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,89 @@
+package fake
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+)
+
+var (
+	appLookupPattern      = regexp.MustCompile(`eureka/apps/([^/]+)(?:/([^/]+))?/?$`)
+	instanceLookupPattern = regexp.MustCompile(`eureka/instances/([^/]+)/?$`)
+	vipLookupPattern      = regexp.MustCompile(`eureka/(vips|svips)/([^/]+)/?$`)
+	statusUpdatePattern   = regexp.MustCompile(`eureka/apps/([^/]+)/([^/]+)/status/?$`)
+	registryPattern       = regexp.MustCompile(`eureka/(apps(/delta)?|vips/[^/]+|svips/[^/]+)/?$`)
+	registrationPattern   = regexp.MustCompile(`/eureka/apps/[\w-]+`)
+	heartbeatPattern      = regexp.MustCompile(`/eureka/apps/[\w-]+/.*(\d{4})$`)
+)
+
+// Parse the path of a single application lookup, example: /eureka/apps/{appID} or /eureka/apps/{appID}/{instanceID}
+// The instance id is empty when the whole application is requested.
+func parseAppLookup(path string) (bool, string, string) {
+
+	matches := appLookupPattern.FindStringSubmatch(path)
+
+	if matches == nil || strings.EqualFold(matches[1], "delta") {
+		return false, "", ""
+	}
+
+	return true, matches[1], matches[2]
+}
+
+// Resolve the status that a status update request is setting, empty if it is not provided.
+func statusUpdate(r *http.Request) eureka2.Status {
+
+	return eureka2.Status(strings.ToUpper(r.URL.Query().Get("value")))
+}
+
+// Check if one of the comma separated vip addresses of an instance matches the requested one.
+func matchesVipAddress(instanceVipAddresses, vipAddress string) bool {
+
+	for _, address := range strings.Split(instanceVipAddresses, ",") {
+		if strings.EqualFold(strings.TrimSpace(address), vipAddress) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Write the entity in the format requested by the client, json is used unless xml is explicitly accepted.
+// The json representation of eureka entities is wrapped in an object while the xml representation is not,
+// hence the two separate entities.
+func writeEntity(w http.ResponseWriter, r *http.Request, jsonEntity, xmlEntity interface{}) {
+
+	if acceptsXml(r) {
+		writeBytes(w, "application/xml", xml.Marshal, xmlEntity)
+		return
+	}
+
+	writeBytes(w, "application/json", json.Marshal, jsonEntity)
+}
+
+// Check if the client requests xml, json is used unless xml is explicitly accepted.
+func acceptsXml(r *http.Request) bool {
+
+	accept := r.Header.Get("Accept")
+
+	return caseInsensitiveContains(accept, "xml") && !caseInsensitiveContains(accept, "json")
+}
+
+func writeBytes(w http.ResponseWriter, contentType string, marshal func(interface{}) ([]byte, error), entity interface{}) {
+
+	bytes, err := marshal(entity)
+
+	if err != nil {
+		log.Printf("Could not marshal %s response err: %s\n", contentType, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(bytes)
+}
@@ -0,0 +1,116 @@
+package fake
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplicationLookup(t *testing.T) {
+	handler := RequestHandler([]*Application{SingleInstanceApp("foo", "foo:1", "127.0.0.1", "localhost", 8080)}, false, time.Second, remoteUpstream())
+
+	for _, path := range []string{"/eureka/apps/FOO", "/eureka/apps/foo/"} {
+		rec := lookup(handler, path, "application/json")
+
+		res := &eureka2.ApplicationResponse{}
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), res), rec.Body.String())
+		assert.Equal(t, "FOO", res.Application.Name)
+		assert.Len(t, res.Application.Instances, 1)
+		assert.Equal(t, "localhost:foo:8080", res.Application.Instances[0].InstanceID)
+	}
+
+	rec := lookup(handler, "/eureka/apps/FOO", "application/xml")
+
+	app := &eureka2.Application{}
+	assert.Equal(t, "application/xml", rec.Header().Get("Content-Type"))
+	assert.Nil(t, xml.Unmarshal(rec.Body.Bytes(), app), rec.Body.String())
+	assert.Equal(t, "FOO", app.Name)
+
+	// the applications that are not faked are looked up in the remote registry
+	assert.Equal(t, "remote", lookup(handler, "/eureka/apps/BAR", "").Body.String())
+}
+
+func TestInstanceLookup(t *testing.T) {
+	handler := RequestHandler([]*Application{SingleInstanceApp("foo", "foo:1", "127.0.0.1", "localhost", 8080)}, false, time.Second, remoteUpstream())
+
+	// the instances are found by the id they were configured with and by the one that is reported to the clients
+	for _, path := range []string{"/eureka/apps/FOO/foo:1", "/eureka/apps/foo/localhost:foo:8080", "/eureka/instances/foo:1", "/eureka/instances/LOCALHOST:FOO:8080"} {
+		rec := lookup(handler, path, "")
+
+		res := &eureka2.InstanceResponse{}
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), res), rec.Body.String())
+		assert.Equal(t, "localhost:foo:8080", res.Instance.InstanceID, path)
+		assert.Equal(t, 8080, res.Instance.Port.Number, path)
+	}
+
+	rec := lookup(handler, "/eureka/instances/foo:1", "application/xml")
+
+	instance := &eureka2.Instance{}
+	assert.Equal(t, "application/xml", rec.Header().Get("Content-Type"))
+	assert.Nil(t, xml.Unmarshal(rec.Body.Bytes(), instance), rec.Body.String())
+	assert.Equal(t, "localhost:foo:8080", instance.InstanceID)
+
+	assert.Equal(t, http.StatusNotFound, lookup(handler, "/eureka/apps/FOO/unknown", "").Code)
+	assert.Equal(t, "remote", lookup(handler, "/eureka/instances/unknown", "").Body.String())
+}
+
+func TestVipLookup(t *testing.T) {
+	remote := newRemoteRegistry(remoteInstance("FOO", 8080, eureka2.UP), remoteInstance("QUX", 8080, eureka2.UP))
+	handler := RequestHandler([]*Application{SingleInstanceApp("foo", "foo:1", "127.0.0.1", "localhost", 8080)}, false, time.Second, remote)
+
+	// the instance is registered with several vip addresses and its own secure vip address
+	serve(handler, http.MethodPost, "/eureka/apps/BAZ", `{"instance":{"instanceId":"localhost:baz:9001","app":"BAZ","hostName":"localhost","ipAddr":"127.0.0.1","status":"UP",`+
+		`"port":{"$":9001,"@enabled":"true"},"vipAddress":"baz, FOO","secureVipAddress":"baz-secure"}}`)
+
+	apps := fetchApps(t, handler, "/eureka/vips/foo")
+
+	// the remote instances of the fake applications are replaced with the fake ones having the vip address
+	assert.Equal(t, []string{"localhost:foo:8080"}, instanceIDs(apps, "FOO"))
+	assert.Equal(t, []string{"localhost:baz:9001"}, instanceIDs(apps, "BAZ"))
+	assert.Equal(t, []string{"remote:qux:8080"}, instanceIDs(apps, "QUX"))
+	assert.Equal(t, apps.ReconcileHashCode(), apps.HashCode)
+
+	apps = fetchApps(t, handler, "/eureka/vips/baz")
+
+	assert.Empty(t, instanceIDs(apps, "FOO"))
+	assert.Equal(t, []string{"localhost:baz:9001"}, instanceIDs(apps, "BAZ"))
+
+	apps = fetchApps(t, handler, "/eureka/svips/foo")
+
+	assert.Equal(t, []string{"localhost:foo:8080"}, instanceIDs(apps, "FOO"))
+	assert.Empty(t, instanceIDs(apps, "BAZ"))
+
+	apps = fetchApps(t, handler, "/eureka/svips/BAZ-SECURE/")
+
+	assert.Empty(t, instanceIDs(apps, "FOO"))
+	assert.Equal(t, []string{"localhost:baz:9001"}, instanceIDs(apps, "BAZ"))
+	assert.Equal(t, []string{"remote:qux:8080"}, instanceIDs(apps, "QUX"))
+}
+
+func lookup(handler http.Handler, path, accept string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+
+	return rec
+}
+
+// A remote registry that answers every request that is passed through.
+func remoteUpstream() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("remote"))
+	})
+}
@@ -0,0 +1,481 @@
+package fake
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+)
+
+// The applications of a registry that is being rewritten.
+type registryApps interface {
+	ContainsApp(ID string) (bool, *eureka2.Application)
+
+	AddApp(app ...*eureka2.Application)
+
+	RemoveApp(ID string)
+}
+
+// A registry document of the remote eureka.
+// The applications are parsed so that they can be rewritten, however only the applications that were looked up, added or removed
+// and the hash code are encoded again. The rest of the document is passed through byte for byte, including the fields that are not modeled.
+type registry struct {
+	apps *eureka2.Applications
+
+	body   []byte
+	isXml  bool
+	spans  map[*eureka2.Application]span
+	order  []*eureka2.Application
+	looked map[*eureka2.Application]bool
+
+	hashCode *span
+	// the array holding the applications, only used for json
+	appsArray *span
+	// the position where new applications are inserted if there is no place for them
+	appsEnd int
+	// whether the applications object has any fields, only used for json
+	hasFields bool
+}
+
+// The position of a value in the registry document
+type span struct {
+	start int
+	end   int
+}
+
+// Parse a registry document in the provided content type, json and xml are supported.
+func parseRegistry(contentType string, body []byte) (*registry, error) {
+
+	reg := &registry{
+		apps:   &eureka2.Applications{Applications: make([]*eureka2.Application, 0)},
+		body:   body,
+		spans:  make(map[*eureka2.Application]span),
+		looked: make(map[*eureka2.Application]bool),
+	}
+
+	if caseInsensitiveContains(contentType, "application/xml") {
+		reg.isXml = true
+		return reg, reg.parseXml()
+	} else if caseInsensitiveContains(contentType, "application/json") {
+		return reg, reg.parseJson()
+	}
+
+	return nil, fmt.Errorf("could not parse registry, unknown Content-Type: %s", contentType)
+}
+
+func (reg *registry) ContainsApp(ID string) (bool, *eureka2.Application) {
+	exists, app := reg.apps.ContainsApp(ID)
+
+	if exists {
+		// the application can be modified once it is looked up
+		reg.looked[app] = true
+	}
+
+	return exists, app
+}
+
+func (reg *registry) AddApp(app ...*eureka2.Application) {
+	reg.apps.AddApp(app...)
+}
+
+func (reg *registry) RemoveApp(ID string) {
+	reg.apps.RemoveApp(ID)
+}
+
+// Replace the hash code of the registry, it is only encoded when the registry already had one.
+func (reg *registry) setHashCode(hashCode string) {
+	reg.apps.HashCode = hashCode
+}
+
+// Compute the hash code of the registry with all the changes applied.
+func (reg *registry) ReconcileHashCode() string {
+	return reg.apps.ReconcileHashCode()
+}
+
+// Encode the registry with all the changes applied.
+func (reg *registry) encode() ([]byte, error) {
+	if reg.isXml {
+		return reg.encodeXml()
+	}
+
+	return reg.encodeJson()
+}
+
+// Check if the application has to be encoded again or the original bytes can be used.
+func (reg *registry) isChanged(app *eureka2.Application) bool {
+	_, original := reg.spans[app]
+
+	return !original || reg.looked[app]
+}
+
+// Check if any application was looked up, added or removed.
+func (reg *registry) isModified() bool {
+	if len(reg.order) != len(reg.apps.Applications) {
+		return true
+	}
+
+	for i, app := range reg.apps.Applications {
+		if app != reg.order[i] || reg.isChanged(app) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (reg *registry) parseJson() error {
+	dec := json.NewDecoder(bytes.NewReader(reg.body))
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		key, err := jsonKey(dec)
+		if err != nil {
+			return err
+		}
+
+		if key == "applications" {
+			return reg.parseJsonApplications(dec)
+		}
+
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("could not parse registry, there are no applications")
+}
+
+func (reg *registry) parseJsonApplications(dec *json.Decoder) error {
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		reg.hasFields = true
+
+		key, err := jsonKey(dec)
+		if err != nil {
+			return err
+		}
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+
+		end := int(dec.InputOffset())
+		valueSpan := span{start: end - len(raw), end: end}
+
+		switch key {
+		case "apps__hashcode":
+			reg.hashCode = &valueSpan
+			_ = json.Unmarshal(raw, &reg.apps.HashCode)
+		case "versions__delta":
+			_ = json.Unmarshal(raw, &reg.apps.Version)
+		case "application":
+			reg.appsArray = &valueSpan
+			if err := reg.parseJsonAppArray(raw, valueSpan.start); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := expectDelim(dec, '}'); err != nil {
+		return err
+	}
+
+	reg.appsEnd = int(dec.InputOffset()) - 1
+
+	return nil
+}
+
+func (reg *registry) parseJsonAppArray(raw json.RawMessage, offset int) error {
+
+	if bytes.HasPrefix(raw, []byte("{")) {
+		// a registry with a single application might not be wrapped in an array
+		return reg.parseJsonApp(raw, span{start: offset, end: offset + len(raw)})
+	}
+
+	if !bytes.HasPrefix(raw, []byte("[")) {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		var element json.RawMessage
+		if err := dec.Decode(&element); err != nil {
+			return err
+		}
+
+		end := offset + int(dec.InputOffset())
+
+		if err := reg.parseJsonApp(element, span{start: end - len(element), end: end}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (reg *registry) parseJsonApp(element json.RawMessage, position span) error {
+	app := &eureka2.Application{}
+
+	if err := json.Unmarshal(element, app); err != nil {
+		return err
+	}
+
+	reg.addOriginal(app, position)
+
+	return nil
+}
+
+func (reg *registry) encodeJson() ([]byte, error) {
+	edits := make([]*edit, 0)
+
+	if reg.hashCode != nil {
+		hashCode, err := json.Marshal(reg.apps.HashCode)
+		if err != nil {
+			return nil, err
+		}
+
+		edits = append(edits, &edit{span: *reg.hashCode, replacement: hashCode})
+	}
+
+	if !reg.isModified() {
+		return applyEdits(reg.body, edits), nil
+	}
+
+	elements := make([][]byte, 0, len(reg.apps.Applications))
+
+	for _, app := range reg.apps.Applications {
+		if !reg.isChanged(app) {
+			elements = append(elements, reg.original(app))
+			continue
+		}
+
+		element, err := json.Marshal(app)
+		if err != nil {
+			return nil, err
+		}
+
+		elements = append(elements, element)
+	}
+
+	array := append(append([]byte("["), bytes.Join(elements, []byte(","))...), ']')
+
+	if reg.appsArray != nil {
+		edits = append(edits, &edit{span: *reg.appsArray, replacement: array})
+	} else {
+		field := append([]byte(`"application":`), array...)
+		if reg.hasFields {
+			field = append([]byte(","), field...)
+		}
+
+		edits = append(edits, &edit{span: span{start: reg.appsEnd, end: reg.appsEnd}, replacement: field})
+	}
+
+	return applyEdits(reg.body, edits), nil
+}
+
+func (reg *registry) parseXml() error {
+	dec := xml.NewDecoder(bytes.NewReader(reg.body))
+	depth := 0
+
+	for {
+		start := int(dec.InputOffset())
+
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if depth == 0 && t.Name.Local != "applications" {
+				return fmt.Errorf("could not parse registry, unexpected root element: %s", t.Name.Local)
+			}
+
+			if depth == 0 {
+				depth++
+				continue
+			}
+
+			if err := reg.parseXmlElement(dec, t, start); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			depth--
+
+			if depth == 0 {
+				reg.appsEnd = start
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("could not parse registry, there are no applications")
+}
+
+func (reg *registry) parseXmlElement(dec *xml.Decoder, element xml.StartElement, start int) error {
+
+	switch element.Name.Local {
+	case "application":
+		app := &eureka2.Application{}
+
+		if err := dec.DecodeElement(app, &element); err != nil {
+			return err
+		}
+
+		reg.addOriginal(app, span{start: start, end: int(dec.InputOffset())})
+	case "apps__hashcode":
+		if err := dec.DecodeElement(&reg.apps.HashCode, &element); err != nil {
+			return err
+		}
+
+		reg.hashCode = &span{start: start, end: int(dec.InputOffset())}
+	case "versions__delta":
+		var version string
+
+		if err := dec.DecodeElement(&version, &element); err != nil {
+			return err
+		}
+
+		reg.apps.Version = version
+	default:
+		return dec.Skip()
+	}
+
+	return nil
+}
+
+func (reg *registry) encodeXml() ([]byte, error) {
+	edits := make([]*edit, 0)
+
+	if reg.hashCode != nil {
+		hashCode := &bytes.Buffer{}
+		hashCode.WriteString("<apps__hashcode>")
+		if err := xml.EscapeText(hashCode, []byte(reg.apps.HashCode)); err != nil {
+			return nil, err
+		}
+		hashCode.WriteString("</apps__hashcode>")
+
+		edits = append(edits, &edit{span: *reg.hashCode, replacement: hashCode.Bytes()})
+	}
+
+	remaining := make(map[*eureka2.Application]bool)
+	for _, app := range reg.apps.Applications {
+		remaining[app] = true
+	}
+
+	for _, app := range reg.order {
+		if !remaining[app] {
+			edits = append(edits, &edit{span: reg.spans[app]})
+		}
+	}
+
+	added := &bytes.Buffer{}
+
+	for _, app := range reg.apps.Applications {
+		if !reg.isChanged(app) {
+			continue
+		}
+
+		element, err := xml.Marshal(app)
+		if err != nil {
+			return nil, err
+		}
+
+		if original, ok := reg.spans[app]; ok {
+			edits = append(edits, &edit{span: original, replacement: element})
+		} else {
+			added.Write(element)
+		}
+	}
+
+	edits = append(edits, &edit{span: span{start: reg.appsEnd, end: reg.appsEnd}, replacement: added.Bytes()})
+
+	return applyEdits(reg.body, edits), nil
+}
+
+func (reg *registry) addOriginal(app *eureka2.Application, position span) {
+	reg.apps.AddApp(app)
+	reg.order = append(reg.order, app)
+	reg.spans[app] = position
+}
+
+func (reg *registry) original(app *eureka2.Application) []byte {
+	position := reg.spans[app]
+
+	return reg.body[position.start:position.end]
+}
+
+// A replacement of a part of the registry document.
+type edit struct {
+	span
+	replacement []byte
+}
+
+// Apply the edits to the document, the edits must not overlap.
+func applyEdits(body []byte, edits []*edit) []byte {
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+
+	result := &bytes.Buffer{}
+	position := 0
+
+	for _, e := range edits {
+		result.Write(body[position:e.start])
+		result.Write(e.replacement)
+		position = e.end
+	}
+
+	result.Write(body[position:])
+
+	return result.Bytes()
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	if token != delim {
+		return fmt.Errorf("could not parse registry, expected '%s' got '%v'", delim, token)
+	}
+
+	return nil
+}
+
+func jsonKey(dec *json.Decoder) (string, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+
+	key, ok := token.(string)
+	if !ok {
+		return "", fmt.Errorf("could not parse registry, expected a key got '%v'", token)
+	}
+
+	return key, nil
+}
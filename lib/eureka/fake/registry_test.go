@@ -0,0 +1,81 @@
+package fake
+
+import (
+	"testing"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+	"github.com/stretchr/testify/assert"
+)
+
+const jsonRegistry = `{"applications":{"versions__delta":"1","apps__hashcode":"UP_2_","futureField":{"a":[1,2]},"application":[
+	{"name":"FOO","instance":[{"instanceId":"foo:1","status":"UP","newField":true}]},
+	{"name":"BAR","instance":[{"instanceId":"bar:1","status":"UP","leaseInfo":{"durationInSecs":90,"newField":1}}]}]}}`
+
+const xmlRegistry = `<applications>
+  <versions__delta>1</versions__delta>
+  <apps__hashcode>UP_2_</apps__hashcode>
+  <futureField>a</futureField>
+  <application>
+    <name>FOO</name>
+    <instance><instanceId>foo:1</instanceId><status>UP</status><newField>true</newField></instance>
+  </application>
+  <application>
+    <name>BAR</name>
+    <instance><instanceId>bar:1</instanceId><status>UP</status><leaseInfo><durationInSecs>90</durationInSecs><newField>1</newField></leaseInfo></instance>
+  </application>
+</applications>`
+
+func TestRegistryJsonPassThrough(t *testing.T) {
+	reg, err := parseRegistry("application/json", []byte(jsonRegistry))
+	assert.Nil(t, err)
+
+	result, err := reg.encode()
+
+	assert.Nil(t, err)
+	assert.Equal(t, jsonRegistry, string(result))
+}
+
+func TestRegistryJsonRewrite(t *testing.T) {
+	reg, err := parseRegistry("application/json", []byte(jsonRegistry))
+	assert.Nil(t, err)
+
+	reg.RemoveApp("foo")
+	reg.AddApp(&eureka2.Application{Name: "BAZ", Instances: []*eureka2.Instance{{InstanceID: "baz:1", Status: eureka2.UP}}})
+	reg.setHashCode("UP_2_CHANGED")
+
+	result, err := reg.encode()
+
+	assert.Nil(t, err)
+	assert.NotContains(t, string(result), `"FOO"`)
+	assert.Contains(t, string(result), `"apps__hashcode":"UP_2_CHANGED","futureField":{"a":[1,2]}`)
+	assert.Contains(t, string(result), `{"name":"BAR","instance":[{"instanceId":"bar:1","status":"UP","leaseInfo":{"durationInSecs":90,"newField":1}}]}`)
+	assert.Contains(t, string(result), `"instanceId":"baz:1"`)
+}
+
+func TestRegistryXmlPassThrough(t *testing.T) {
+	reg, err := parseRegistry("application/xml", []byte(xmlRegistry))
+	assert.Nil(t, err)
+
+	result, err := reg.encode()
+
+	assert.Nil(t, err)
+	assert.Equal(t, xmlRegistry, string(result))
+}
+
+func TestRegistryXmlRewrite(t *testing.T) {
+	reg, err := parseRegistry("application/xml", []byte(xmlRegistry))
+	assert.Nil(t, err)
+
+	reg.RemoveApp("foo")
+	reg.AddApp(&eureka2.Application{Name: "BAZ"})
+	reg.setHashCode("UP_1_")
+
+	result, err := reg.encode()
+
+	assert.Nil(t, err)
+	assert.NotContains(t, string(result), `<name>FOO</name>`)
+	assert.Contains(t, string(result), `<apps__hashcode>UP_1_</apps__hashcode>`)
+	assert.Contains(t, string(result), `<futureField>a</futureField>`)
+	assert.Contains(t, string(result), `<leaseInfo><durationInSecs>90</durationInSecs><newField>1</newField></leaseInfo>`)
+	assert.Contains(t, string(result), `<application><name>BAZ</name></application></applications>`)
+}
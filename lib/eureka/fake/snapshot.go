@@ -0,0 +1,183 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+	"github.com/newestuser/eureka-proxy/lib/httputil"
+)
+
+// Fetch the registry of the upstream eureka the same way the eureka clients do.
+// The registry is returned as json so that it can be replayed later.
+func Snapshot(upstream http.Handler) ([]byte, error) {
+
+	r, err := http.NewRequest(http.MethodGet, "/eureka/apps", http.NoBody)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.Header.Set("Accept", "application/json")
+
+	rec := httputil.DetachedRecorder()
+
+	upstream.ServeHTTP(rec, r)
+
+	if rec.Status() != http.StatusOK {
+		return nil, fmt.Errorf("the registry responded with status: %d", rec.Status())
+	}
+
+	apps, err := deserialize(rec)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !apps.isXml {
+		return apps.body, nil
+	}
+
+	return json.Marshal(&eureka2.State{Apps: apps.apps})
+}
+
+// Create a handler that serves the registry from a snapshot in place of a remote eureka.
+// The registry never changes, hence the delta is always empty.
+func ReplayHandler(snapshot []byte) (http.Handler, error) {
+
+	apps, err := parseRegistry("application/json", snapshot)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the snapshot err: %s", err.Error())
+	}
+
+	return &snapshotRegistry{body: snapshot, apps: apps.apps}, nil
+}
+
+// A remote registry that was saved in a snapshot.
+type snapshotRegistry struct {
+	body []byte
+	apps *eureka2.Applications
+}
+
+func (reg *snapshotRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+
+	if strings.HasSuffix(path, "eureka/apps") {
+		reg.respondWithApps(w, r)
+		return
+	}
+
+	if strings.HasSuffix(path, "eureka/apps/delta") {
+		delta := &eureka2.Applications{Version: reg.apps.Version, HashCode: reg.apps.ReconcileHashCode(), Applications: make([]*eureka2.Application, 0)}
+
+		writeEntity(w, r, &eureka2.State{Apps: delta}, delta)
+		return
+	}
+
+	if matches := vipLookupPattern.FindStringSubmatch(path); matches != nil {
+		apps := reg.vipApps(matches[2], matches[1] == "svips")
+
+		writeEntity(w, r, &eureka2.State{Apps: apps}, apps)
+		return
+	}
+
+	if ok, appID, instanceID := parseAppLookup(path); ok {
+		reg.respondWithApp(w, r, appID, instanceID)
+		return
+	}
+
+	if matches := instanceLookupPattern.FindStringSubmatch(path); matches != nil {
+		if instance := reg.findInstance(matches[1]); instance != nil {
+			writeEntity(w, r, &eureka2.InstanceResponse{Instance: instance}, instance)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// The snapshot is served as it was saved unless the client requests xml.
+func (reg *snapshotRegistry) respondWithApps(w http.ResponseWriter, r *http.Request) {
+
+	if acceptsXml(r) {
+		writeEntity(w, r, &eureka2.State{Apps: reg.apps}, reg.apps)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(reg.body)
+}
+
+func (reg *snapshotRegistry) respondWithApp(w http.ResponseWriter, r *http.Request, appID, instanceID string) {
+
+	exists, app := reg.apps.ContainsApp(appID)
+
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if instanceID == "" {
+		writeEntity(w, r, &eureka2.ApplicationResponse{Application: app}, app)
+		return
+	}
+
+	for _, instance := range app.Instances {
+		if strings.EqualFold(instance.InstanceID, instanceID) {
+			writeEntity(w, r, &eureka2.InstanceResponse{Instance: instance}, instance)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// The applications with only the instances that are registered with the vip address.
+func (reg *snapshotRegistry) vipApps(vipAddress string, secure bool) *eureka2.Applications {
+	apps := &eureka2.Applications{Version: reg.apps.Version, Applications: make([]*eureka2.Application, 0)}
+
+	for _, app := range reg.apps.Applications {
+		instances := make([]*eureka2.Instance, 0)
+
+		for _, instance := range app.Instances {
+			instanceVipAddress := instance.VIPAddress
+			if secure {
+				instanceVipAddress = instance.SecureVIPAddress
+			}
+
+			if matchesVipAddress(instanceVipAddress, vipAddress) {
+				instances = append(instances, instance)
+			}
+		}
+
+		if len(instances) > 0 {
+			apps.AddApp(&eureka2.Application{Name: app.Name, Instances: instances})
+		}
+	}
+
+	apps.HashCode = apps.ReconcileHashCode()
+
+	return apps
+}
+
+func (reg *snapshotRegistry) findInstance(instanceID string) *eureka2.Instance {
+	for _, app := range reg.apps.Applications {
+		for _, instance := range app.Instances {
+			if strings.EqualFold(instance.InstanceID, instanceID) {
+				return instance
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package fake
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotReplay(t *testing.T) {
+	snapshot, err := Snapshot(environment("dev", "PAYMENTS", "USERS").Handler)
+	assert.Nil(t, err)
+
+	upstream, err := ReplayHandler(snapshot)
+	assert.Nil(t, err)
+
+	handler := RequestHandler([]*Application{SingleInstanceApp("foo", "foo:1", "127.0.0.1", "localhost", 8080)}, false, time.Second, upstream)
+
+	apps := fetchApps(t, handler, "/eureka/apps")
+
+	assert.Equal(t, "dev", envOf(apps, "PAYMENTS"))
+	assert.Equal(t, "dev", envOf(apps, "USERS"))
+	assert.Equal(t, "localhost", envOf(apps, "FOO"))
+
+	delta := fetchApps(t, handler, "/eureka/apps/delta")
+
+	assert.Equal(t, apps.HashCode, delta.HashCode)
+
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodGet, "/eureka/apps/PAYMENTS", ""))
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodGet, "/eureka/apps/ORDERS", ""))
+}
+
+func TestSnapshotOfUnavailableRegistry(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	_, err := Snapshot(upstream)
+
+	assert.NotNil(t, err)
+}
+
+func TestReplayOfInvalidSnapshot(t *testing.T) {
+	_, err := ReplayHandler([]byte("not json"))
+
+	assert.NotNil(t, err)
+
+	upstream, err := ReplayHandler([]byte(`{"applications":{"versions__delta":"1","apps__hashcode":"","application":[]}}`))
+	assert.Nil(t, err)
+
+	rec := httptest.NewRecorder()
+	upstream.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/eureka/apps/FOO", nil))
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
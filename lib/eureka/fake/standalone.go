@@ -0,0 +1,35 @@
+package fake
+
+import (
+	"net/http"
+	"time"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+)
+
+// Create a handler that acts as a complete in-memory eureka registry without a remote eureka behind it.
+// Services register, send heartbeats and deregister the same way they do when proxying,
+// the only difference is that every other service will be missing from the registry.
+func StandaloneHandler(fakeApps []*Application, leaseDuration time.Duration) Registry {
+
+	st := newState(fakeApps, false, &emptyRegistry{})
+	st.leaseDuration = leaseDuration
+
+	return st
+}
+
+// A remote registry that has no applications registered.
+type emptyRegistry struct {
+}
+
+func (reg *emptyRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method == http.MethodGet && registryPattern.MatchString(r.URL.Path) {
+		apps := &eureka2.Applications{Version: "1", Applications: make([]*eureka2.Application, 0)}
+
+		writeEntity(w, r, &eureka2.State{Apps: apps}, apps)
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+}
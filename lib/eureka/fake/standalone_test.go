@@ -0,0 +1,87 @@
+package fake
+
+import (
+	"encoding/xml"
+	"net/http"
+	"testing"
+	"time"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStandaloneRegistry(t *testing.T) {
+	clock := &testClock{t: time.Now()}
+
+	handler := StandaloneHandler([]*Application{SingleInstanceApp("static", "static:1", "127.0.0.1", "localhost", 8080)}, time.Minute).(*state)
+	handler.now = clock.now
+
+	apps := fetchApps(t, handler, "/eureka/apps")
+
+	assert.Equal(t, []string{"localhost:static:8080"}, instanceIDs(apps, "STATIC"))
+	assert.Equal(t, "UP_1_", apps.HashCode)
+
+	assert.Equal(t, http.StatusNoContent, serve(handler, http.MethodPost, "/eureka/apps/FOO", registration("FOO", "localhost:foo:9001", 9001)))
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodPut, "/eureka/apps/FOO/localhost:foo:9001", ""))
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodPut, "/eureka/apps/FOO/localhost:foo:9002", ""))
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodPut, "/eureka/apps/FOO/localhost:foo:9001/status?value=OUT_OF_SERVICE", ""))
+
+	apps = fetchApps(t, handler, "/eureka/apps")
+	delta := fetchApps(t, handler, "/eureka/apps/delta")
+
+	assert.Equal(t, []string{"localhost:foo:9001"}, instanceIDs(apps, "FOO"))
+	assert.Equal(t, map[string]string{"localhost:foo:9001": eureka2.MODIFIED}, actions(delta, "FOO"))
+	assert.Equal(t, "OUT_OF_SERVICE_1_UP_1_", delta.HashCode)
+	assert.Equal(t, apps.HashCode, delta.HashCode)
+
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodDelete, "/eureka/apps/FOO/localhost:foo:9001", ""))
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodDelete, "/eureka/apps/FOO/localhost:foo:9001", ""))
+
+	delta = fetchApps(t, handler, "/eureka/apps/delta")
+
+	assert.Empty(t, instanceIDs(fetchApps(t, handler, "/eureka/apps"), "FOO"))
+	assert.Equal(t, map[string]string{"localhost:foo:9001": eureka2.DELETED}, actions(delta, "FOO"))
+	assert.Equal(t, "UP_1_", delta.HashCode)
+}
+
+func TestStandaloneEviction(t *testing.T) {
+	clock := &testClock{t: time.Now()}
+
+	handler := StandaloneHandler([]*Application{SingleInstanceApp("static", "static:1", "127.0.0.1", "localhost", 8080)}, time.Minute).(*state)
+	handler.now = clock.now
+
+	serve(handler, http.MethodPost, "/eureka/apps/FOO", registration("FOO", "localhost:foo:9001", 9001))
+	serve(handler, http.MethodPost, "/eureka/apps/BAR", registration("BAR", "localhost:bar:9002", 9002))
+
+	clock.add(45 * time.Second)
+	serve(handler, http.MethodPut, "/eureka/apps/FOO/localhost:foo:9001", "")
+	clock.add(45 * time.Second)
+
+	// the instance that stopped sending heartbeats is evicted, the fakes from the configuration are never evicted
+	apps := fetchApps(t, handler, "/eureka/apps")
+
+	assert.Equal(t, []string{"localhost:foo:9001"}, instanceIDs(apps, "FOO"))
+	assert.Empty(t, instanceIDs(apps, "BAR"))
+	assert.Equal(t, []string{"localhost:static:8080"}, instanceIDs(apps, "STATIC"))
+
+	delta := fetchApps(t, handler, "/eureka/apps/delta")
+
+	assert.Equal(t, map[string]string{"localhost:bar:9002": eureka2.DELETED}, actions(delta, "BAR"))
+}
+
+func TestStandaloneUnknownResources(t *testing.T) {
+	handler := StandaloneHandler(nil, time.Minute)
+
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodGet, "/eureka/apps/FOO", ""))
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodGet, "/eureka/instances/localhost:foo:9001", ""))
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodPut, "/eureka/apps/FOO/localhost:foo:9001", ""))
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodPut, "/eureka/apps/FOO/localhost:foo:9001/status?value=DOWN", ""))
+
+	// the empty registry is served in the format the client accepts
+	rec := lookup(handler, "/eureka/apps", "application/xml")
+
+	apps := &eureka2.Applications{}
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Nil(t, xml.Unmarshal(rec.Body.Bytes(), apps), rec.Body.String())
+	assert.Empty(t, apps.Applications)
+}
@@ -1,21 +1,30 @@
 package fake
 
 import (
-	"encoding/json"
-	"encoding/xml"
+	"bytes"
 	"fmt"
 	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
 	"github.com/newestuser/eureka-proxy/lib/httputil"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
-	"regexp"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-func RequestHandler(fakeApps []*Application, pollute bool, chain http.Handler) http.Handler {
+// Create a handler that injects the fake applications in the registry of the eureka behind the chain.
+// Services that register through the handler are evicted when they do not send a heartbeat within their lease,
+// the lease duration is used for the services that did not register with one. A zero lease duration disables the eviction.
+func RequestHandler(fakeApps []*Application, pollute bool, leaseDuration time.Duration, chain http.Handler) Registry {
+
+	st := newState(fakeApps, pollute, chain)
+	st.leaseDuration = leaseDuration
+
+	return st
+}
+
+func newState(fakeApps []*Application, pollute bool, chain http.Handler) *state {
 
 	fakes := make(map[string]*appCluster)
 
@@ -32,79 +41,201 @@ func RequestHandler(fakeApps []*Application, pollute bool, chain http.Handler) h
 		fakes[fakeApp.ID] = cluster
 	}
 
-	return &state{fakeApps: fakes, pollutionOn: pollute, chain: chain}
+	return &state{fakeApps: fakes, pollutionOn: pollute, chain: chain, changes: newChangeLog(), now: time.Now}
+}
+
+// A handler serving the registry with the fakes, the fakes from the configuration can be replaced while it is serving.
+type Registry interface {
+	http.Handler
+
+	// Replace the fakes from the configuration and the default lease duration.
+	// The services detected by the proxy and the fakes added through the admin api are kept.
+	Reload(fakeApps []*Application, leaseDuration time.Duration)
 }
 
 // A representation of the entire fake application configuration
-// that will be injected in the original eureka applications list
+// that will be injected in the original eureka applications list.
+// The handler is used from concurrent requests, the fake applications and their instances are guarded by the mutex.
 type state struct {
+	mu          sync.Mutex
 	fakeApps    map[string]*appCluster
 	pollutionOn bool
 	chain       http.Handler
+	changes     *changeLog
+	remote      remoteStatuses
+
+	// The duration after which instances that stopped sending heartbeats are evicted, unless they registered with their own.
+	// Zero means they are never evicted.
+	leaseDuration time.Duration
+	now           func() time.Time
 }
 
 func (st *state) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
+	st.evictExpired()
+
+	if st.isDashboardRequest(r) {
+
+		st.serveDashboard(w, r)
+		return
+	}
+
+	if st.isAdminRequest(r) {
+
+		st.serveAdmin(w, r)
+		return
+	}
+
+	if st.isRequestingDelta(r) {
+
+		st.respondWithDelta(w, r)
+		return
+	}
+
 	if st.isRequestingApps(r) {
 
 		st.respondWithFakes(w, r)
 		return
 	}
 
+	if ok, vipAddress, secure := st.isRequestingVip(r); ok {
+
+		st.respondWithVipFakes(w, r, vipAddress, secure)
+		return
+	}
+
+	if st.isRegistrationRequest(r) {
+		// the registration is read before the registry is locked, a slow client should not block the rest of them
+		if err := bufferBody(r); err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+	}
+
+	if st.serveFakes(w, r) {
+		return
+	}
+
+	if st.pollutionOn {
+		st.chain.ServeHTTP(w, r)
+		return
+	}
+
+	if st.isStatusUpdateRequest(r) {
+		log.Printf("A status update for an instance that is not registered locally was detected. It will not reach the real Eureka: %s %s\n", r.Method, r.URL.Path)
+
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if st.injectDetected(w, r) {
+		return
+	}
+
+	st.chain.ServeHTTP(w, r)
+}
+
+// Handle the requests that are targeting the fake applications, return false if the request is not targeting any of them.
+func (st *state) serveFakes(w http.ResponseWriter, r *http.Request) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if ok, instanceID := st.isRequestingInstance(r); ok {
+		for _, appCluster := range st.fakeApps {
+			if instance := appCluster.findInstance(instanceID); instance != nil {
+
+				writeEntity(w, r, &eureka2.InstanceResponse{Instance: instance}, instance)
+				return true
+			}
+		}
+	}
+
 	for _, appCluster := range st.fakeApps {
+		if ok, instanceID := appCluster.isStatusUpdateRequest(r); ok {
+
+			st.updateStatus(w, r, appCluster, instanceID)
+			return true
+		}
+
 		if appCluster.isRegistrationRequest(r) {
 
+			if st.isRegistrationRequest(r) {
+				if err := st.register(r); err != nil {
+					respondWithError(w, r, err)
+					return true
+				}
+			}
+
 			appCluster.successfullyRegister(w)
-			return
+			return true
 		}
 
 		if appCluster.isHeartbeatRequest(r) {
 
+			if !appCluster.renew(r, st.now()) {
+				st.unknownHeartbeat(w, r)
+				return true
+			}
+
 			appCluster.successfulHeartbeat(w)
-			return
+			return true
 		}
 
 		if appCluster.isRequestingInstances(r) {
 
-			appCluster.returnInstances(w)
-			return
+			appCluster.returnInstances(w, r)
+			return true
 		}
 
 		if ok, instanceId := appCluster.isDeregistrationRequest(r); ok {
 
-			if ok, instance := appCluster.deregister(instanceId); ok {
+			ok, instance := appCluster.deregister(instanceId)
+
+			if ok {
 				log.Printf("A deregistration request was detected. Deregistering: %s instance: %s\n", appCluster.ID, instance)
+				st.changes.record(eureka2.DELETED, appCluster.ID, instance)
 			}
 
 			if appCluster.noInstances() {
 				st.removeFakeApp(appCluster)
 			}
 
+			if !ok {
+				appCluster.unknownInstance(w)
+				return true
+			}
+
 			appCluster.successfullyDeregister(w)
-			return
+			return true
 		}
 	}
 
-	if st.pollutionOn {
-		st.chain.ServeHTTP(w, r)
-		return
-	}
+	return false
+}
+
+// Inject the services that register without being known to the proxy, the services that send heartbeats without being
+// registered are asked to register. Return false if the request is neither a registration nor a heartbeat.
+func (st *state) injectDetected(w http.ResponseWriter, r *http.Request) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
 
-	if registration, app := st.isRegistrationRequest(r); registration {
-		st.injectFakeApp(app)
+	if st.isRegistrationRequest(r) {
+		if err := st.register(r); err != nil {
+			respondWithError(w, r, err)
+			return true
+		}
 
 		st.successfullyRegister(w)
-		return
+		return true
 	}
 
-	if heartbeat, app := st.isHeartbeatRequest(r); heartbeat {
-		st.injectFakeApp(app)
+	if st.isHeartbeatRequest(r) {
 
-		st.successfulHeartbeat(w)
-		return
+		st.unknownHeartbeat(w, r)
+		return true
 	}
 
-	st.chain.ServeHTTP(w, r)
+	return false
 }
 
 func (st *state) isRequestingApps(r *http.Request) bool {
@@ -114,86 +245,278 @@ func (st *state) isRequestingApps(r *http.Request) bool {
 	return r.Method == http.MethodGet && isGetAppsUrl
 }
 
+func (st *state) isRequestingDelta(r *http.Request) bool {
+
+	isGetDeltaUrl := strings.HasSuffix(r.URL.Path, "eureka/apps/delta") || strings.HasSuffix(r.URL.Path, "eureka/apps/delta/")
+
+	return r.Method == http.MethodGet && isGetDeltaUrl
+}
+
+// Check if the request is looking up the instances by vip address, example: GET /eureka/vips/{vipAddress} or GET /eureka/svips/{svipAddress}
+func (st *state) isRequestingVip(r *http.Request) (bool, string, bool) {
+	if r.Method != http.MethodGet {
+		return false, "", false
+	}
+
+	matches := vipLookupPattern.FindStringSubmatch(r.URL.Path)
+
+	if matches == nil {
+		return false, "", false
+	}
+
+	return true, matches[2], matches[1] == "svips"
+}
+
+// Check if the request is looking up a single instance by its id, example: GET /eureka/instances/{id}
+func (st *state) isRequestingInstance(r *http.Request) (bool, string) {
+	if r.Method != http.MethodGet {
+		return false, ""
+	}
+
+	matches := instanceLookupPattern.FindStringSubmatch(r.URL.Path)
+
+	if matches == nil {
+		return false, ""
+	}
+
+	return true, matches[1]
+}
+
 func (st *state) respondWithFakes(w http.ResponseWriter, r *http.Request) {
-	rec := httputil.Recorder(w)
 
-	st.chain.ServeHTTP(rec, r)
+	st.rewriteRegistry(w, r, func(apps *registry) {
+		st.mergeFullRegistry(apps)
 
-	state := deserialize(rec)
+		if len(st.fakeApps) > 0 {
+			fakeApps := make([]string, 0)
 
-	for _, appCluster := range st.fakeApps {
+			for _, clust := range st.fakeApps {
+				fakeApps = append(fakeApps, clust.ID)
+			}
 
-		if appExists, existingApp := state.Apps.ContainsApp(appCluster.ID); appExists {
+			log.Printf("Will respond with the following fake services:\n\n%s\n\n", strings.Join(fakeApps, "\n"))
+		}
+	})
+}
 
-			instances := appCluster.NewInstances()
-			existingApp.ReplaceInstances(instances)
+// Merge the fakes into the full registry, the instances of the remote registry are kept for adjusting the hash code of the deltas.
+func (st *state) mergeFullRegistry(apps *registry) {
+	st.remote.reset(apps.apps)
+	st.mergeFakes(apps)
+	apps.setHashCode(apps.ReconcileHashCode())
+}
+
+// Respond with the delta of the remote registry where the changes of the fake applications are
+// replaced with the recent changes of the fake instances.
+func (st *state) respondWithDelta(w http.ResponseWriter, r *http.Request) {
+
+	st.mu.Lock()
+	unknown := !st.remote.known && len(st.fakeApps) > 0
+	st.mu.Unlock()
+
+	if unknown {
+		// the full registry is fetched upfront since the remote eureka must not be called while the registry is locked
+		st.fetchRemoteStatuses(r)
+	}
+
+	st.rewriteRegistry(w, r, func(delta *registry) {
+		st.remote.apply(delta.apps)
+
+		hashCode, err := st.deltaHashCode(delta.apps.HashCode)
+
+		for _, appCluster := range st.fakeApps {
+			delta.RemoveApp(appCluster.ID)
+		}
+
+		st.changes.applyTo(delta)
+
+		if err == nil {
+			delta.setHashCode(hashCode)
 		} else {
+			// the clients will notice the mismatch and fall back to a full fetch
+			log.Printf("Could not compute the hash code of the delta err: %s\n", err.Error())
+		}
+	})
+}
+
+// Respond with the instances of the remote registry that are registered with the vip address
+// where the instances of the fake applications are replaced with the fake ones having the same vip address.
+func (st *state) respondWithVipFakes(w http.ResponseWriter, r *http.Request, vipAddress string, secure bool) {
 
-			state.Apps.AddApp(appCluster.NewEurekaApp())
+	st.rewriteRegistry(w, r, func(apps *registry) {
+		for _, appCluster := range st.fakeApps {
+			apps.RemoveApp(appCluster.ID)
+
+			if app := appCluster.NewVipApp(vipAddress, secure); app != nil {
+				apps.AddApp(app)
+			}
 		}
+
+		apps.setHashCode(apps.ReconcileHashCode())
+	})
+}
+
+// Rewrite the registry that the remote eureka responded with before it reaches the client, the registry is locked during the rewrite.
+// Unsuccessful responses and responses that cannot be rewritten are passed to the client as they are.
+func (st *state) rewriteRegistry(w http.ResponseWriter, r *http.Request, rewrite func(apps *registry)) {
+	rec := httputil.Recorder(w)
+
+	st.chain.ServeHTTP(rec, r)
+
+	if rec.Status() != http.StatusOK {
+		rec.Flush()
+		return
 	}
 
-	appBytes := serialize(rec, state)
+	apps, err := deserialize(rec)
 
-	if rec.Header().Get("Content-Encoding") == "gzip" {
-		appBytes = httputil.Gzip(appBytes)
+	if err != nil {
+		log.Printf("The registry of %s %s will be passed through without the fakes err: %s\n", r.Method, r.URL.Path, err.Error())
+		rec.Flush()
+		return
 	}
 
-	if len(st.fakeApps) > 0 {
-		fakeApps := make([]string, 0)
+	st.mu.Lock()
+	rewrite(apps)
+	st.mu.Unlock()
 
-		for _, clust := range st.fakeApps {
-			fakeApps = append(fakeApps, clust.ID)
-		}
+	appBytes, err := serialize(rec, apps)
 
-		log.Printf("Will respond with the following fake services:\n\n%s\n\n", strings.Join(fakeApps, "\n"))
+	if err != nil {
+		log.Printf("The registry of %s %s will be passed through without the fakes err: %s\n", r.Method, r.URL.Path, err.Error())
+		rec.Flush()
+		return
 	}
 
 	rec.FlushWith(appBytes)
+}
+
+// Eureka clients compare the hash code of the delta with the hash code of their local registry after the delta is applied.
+// Since the local registry of the clients contains the fakes the hash code of the remote registry is adjusted for them,
+// otherwise the clients will fall back to a full fetch on every poll. The registry must be locked.
+func (st *state) deltaHashCode(remoteHashCode string) (string, error) {
+	removed := make([]string, 0)
+	added := make([]string, 0)
+
+	for _, appCluster := range st.fakeApps {
+		removed = append(removed, st.remote.of(appCluster.ID)...)
+
+		for _, instance := range appCluster.NewInstances() {
+			added = append(added, string(instance.Status))
+		}
+	}
+
+	if len(removed) == 0 && len(added) == 0 {
+		return remoteHashCode, nil
+	}
 
-	return
+	if !st.remote.known {
+		return "", fmt.Errorf("the instances of the remote registry are unknown")
+	}
+
+	return adjustHashCode(remoteHashCode, removed, added)
 }
 
-func (st *state) isRegistrationRequest(r *http.Request) (bool, *Application) {
+// Fetch the full registry to learn the instances of the remote registry, it is only needed when
+// a client fetches the delta before any client fetched the full registry through the proxy.
+func (st *state) fetchRemoteStatuses(deltaReq *http.Request) {
+	r := deltaReq.Clone(deltaReq.Context())
+	r.URL.Path = strings.TrimSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/delta")
+	r.URL.RawPath = ""
+
+	rec := httputil.DetachedRecorder()
+
+	st.chain.ServeHTTP(rec, r)
+
+	if rec.Status() != http.StatusOK {
+		log.Printf("Could not fetch the full registry, status: %d\n", rec.Status())
+		return
+	}
 
-	isRegistrationUrl, err := regexp.MatchString(`/eureka/apps/[\w-]+`, r.URL.Path)
+	apps, err := deserialize(rec)
 
 	if err != nil {
-		panic(err)
+		log.Printf("Could not fetch the full registry err: %s\n", err.Error())
+		return
 	}
 
-	isRegistrationReq := r.Method == http.MethodPost && isRegistrationUrl
+	st.mu.Lock()
+	st.remote.reset(apps.apps)
+	st.mu.Unlock()
+}
+
+// Replace the instances of the remote applications with the fake ones and add the fake applications that are missing.
+func (st *state) mergeFakes(apps registryApps) {
+	for _, appCluster := range st.fakeApps {
+
+		if appExists, existingApp := apps.ContainsApp(appCluster.ID); appExists {
 
-	if !isRegistrationReq {
-		return false, nil
+			instances := appCluster.NewInstances()
+			existingApp.ReplaceInstances(instances)
+		} else {
+
+			apps.AddApp(appCluster.NewEurekaApp())
+		}
 	}
+}
 
-	instance := readInstance(r)
+func (st *state) isStatusUpdateRequest(r *http.Request) bool {
 
-	app := SingleInstanceApp(instance.App, instance.InstanceID, instance.IPAddress, instance.HostName, instance.Port.Number)
+	isStatusMethod := r.Method == http.MethodPut || r.Method == http.MethodDelete
 
-	return true, app
+	return isStatusMethod && statusUpdatePattern.MatchString(r.URL.Path)
 }
 
-func (st *state) isHeartbeatRequest(r *http.Request) (bool, *Application) {
+// Override the status of a fake instance or remove the override.
+func (st *state) updateStatus(w http.ResponseWriter, r *http.Request, appCluster *appCluster, instanceID string) {
+
+	status := statusUpdate(r)
+
+	var found bool
+	var target *Target
+
+	if r.Method == http.MethodDelete {
+		found, target = appCluster.removeStatusOverride(instanceID, status)
+	} else if status == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else {
+		found, target = appCluster.overrideStatus(instanceID, status)
+	}
 
-	if r.Method != http.MethodPut {
-		return false, nil
+	if !found {
+		appCluster.unknownInstance(w)
+		return
 	}
 
-	isHeartbeatUrl, err := regexp.MatchString(`/eureka/apps/[\w-]+/.*(\d{4})$`, r.URL.Path)
+	log.Printf("A status update was detected. The status of %s instance: %s is %s\n", appCluster.ID, target, target.EffectiveStatus())
+	st.changes.record(eureka2.MODIFIED, appCluster.ID, target)
+
+	appCluster.successfulStatusUpdate(w)
+}
+
+func (st *state) isRegistrationRequest(r *http.Request) bool {
+
+	return r.Method == http.MethodPost && registrationPattern.MatchString(r.URL.Path)
+}
+
+// Inject the instance that is registering.
+func (st *state) register(r *http.Request) error {
+
+	instance, err := readInstance(r)
 
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	if !isHeartbeatUrl {
-		return false, nil
-	}
+	st.injectFakeApp(RegisteredApp(instance), fmt.Sprintf("registration from %s", r.RemoteAddr))
+
+	return nil
+}
 
-	appId, instanceId, port := parseAppIDAndInstanceID(r.URL)
+func (st *state) isHeartbeatRequest(r *http.Request) bool {
 
-	return true, SingleLocalAppWithInstance(appId, instanceId, port)
+	return r.Method == http.MethodPut && heartbeatPattern.MatchString(r.URL.Path)
 }
 
 func (st *state) successfullyRegister(w http.ResponseWriter) {
@@ -202,110 +525,209 @@ func (st *state) successfullyRegister(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (st *state) successfulHeartbeat(w http.ResponseWriter) {
+// Respond to the heartbeat of an instance that is not registered, for example because it was evicted.
+// Same as eureka the response is 404 so that the client registers again with its full registration.
+func (st *state) unknownHeartbeat(w http.ResponseWriter, r *http.Request) {
+	log.Printf("A heartbeat from an instance that is not registered was detected. It will be asked to register: %s %s\n", r.Method, r.URL.Path)
 
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(http.StatusNotFound)
 }
 
-func (st *state) injectFakeApp(app *Application) {
+// Inject the service that was detected from the request described by the source.
+func (st *state) injectFakeApp(app *Application, source string) {
 	log.Printf("A new service was detected. Injecting: %s\n", app)
 
-	clust := st.fakeApps[app.ID]
+	for _, target := range app.Instances() {
+		target.lastRenewal = st.now()
+		target.origin = detectedOrigin
+		target.source = source
+	}
+
+	st.addFakeApp(app)
+}
+
+// Add the instances of the application to the fakes and record the changes for the clients fetching the delta.
+func (st *state) addFakeApp(app *Application) {
+
+	clust := st.findCluster(app.ID)
 
 	if clust == nil {
 		clust = &appCluster{ID: app.ID}
 	}
 
+	for _, target := range app.Instances() {
+		var existing *Target
+		if clust.Application != nil {
+			existing = clust.findTarget(target.InstanceID)
+		}
+
+		if existing == nil {
+			st.changes.record(eureka2.ADDED, clust.ID, target)
+		} else {
+			// the status override outlives the registration, same as in eureka
+			target.OverriddenStatus = existing.OverriddenStatus
+
+			// every registration is reported same as in eureka, the payload may have changed while the reported fields did not
+			if target.Registration != nil || target.isChangedFrom(existing) {
+				st.changes.record(eureka2.MODIFIED, clust.ID, target)
+			}
+		}
+	}
+
 	clust.add(app)
 
-	st.fakeApps[app.ID] = clust
+	st.fakeApps[clust.ID] = clust
 }
 
-func (st *state) removeFakeApp(cluster *appCluster) {
-	delete(st.fakeApps, cluster.ID)
+// Find the fake application with the id, the id is case insensitive same as in eureka.
+func (st *state) findCluster(appID string) *appCluster {
+
+	if clust := st.fakeApps[appID]; clust != nil {
+		return clust
+	}
+
+	for _, clust := range st.fakeApps {
+		if strings.EqualFold(clust.ID, appID) {
+			return clust
+		}
+	}
+
+	return nil
 }
 
-func readInstance(r *http.Request) *eureka2.Instance {
+func (st *state) Reload(fakeApps []*Application, leaseDuration time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
 
-	bytes, e := ioutil.ReadAll(r.Body)
-	defer r.Body.Close()
+	st.leaseDuration = leaseDuration
 
-	if e != nil {
-		panic(e)
+	configured := make(map[string]bool)
+
+	for _, fakeApp := range fakeApps {
+		for _, target := range fakeApp.Instances() {
+			configured[strings.ToLower(fakeApp.ID+"/"+target.InstanceID)] = true
+		}
 	}
 
-	req := &eureka2.RegistrationRequest{}
+	for _, appCluster := range st.fakeApps {
+		for _, target := range appCluster.Instances() {
+
+			if target.Origin() != string(staticOrigin) || configured[strings.ToLower(appCluster.ID+"/"+target.InstanceID)] {
+				continue
+			}
 
-	if err := json.Unmarshal(bytes, req); err != nil {
-		panic(err)
+			if ok, instance := appCluster.deregister(target.InstanceID); ok {
+				log.Printf("The fake was removed from the configuration. Removing: %s instance: %s\n", appCluster.ID, instance)
+				st.changes.record(eureka2.DELETED, appCluster.ID, instance)
+			}
+		}
+
+		if appCluster.noInstances() {
+			st.removeFakeApp(appCluster)
+		}
 	}
 
-	return req.Instance
+	for _, fakeApp := range fakeApps {
+		st.addFakeApp(fakeApp)
+	}
 }
 
-func parseAppIDAndInstanceID(u *url.URL) (string, string, int) {
-	parts := strings.Split(u.Path, `/`)
+func (st *state) removeFakeApp(cluster *appCluster) {
+	delete(st.fakeApps, cluster.ID)
+}
 
-	appId := parts[len(parts)-2]
-	instanceId := parts[len(parts)-1]
-	port, e := strconv.Atoi(u.Path[len(u.Path)-4:])
+// Evict the instances that did not send a heartbeat within the lease duration.
+// Instances that were never registered, like the ones from the configuration, are never evicted.
+func (st *state) evictExpired() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
 
-	if e != nil {
-		panic(e)
+	if st.leaseDuration == 0 {
+		return
 	}
 
-	return appId, instanceId, port
-}
+	now := st.now()
+
+	for _, appCluster := range st.fakeApps {
+		for _, target := range appCluster.Instances() {
+
+			if !target.isExpired(now, st.leaseDuration) {
+				continue
+			}
 
-func deserialize(rec *httputil.HttpResponseRecorder) *eureka2.State {
-	contentType := rec.Header().Get("Content-Type")
-	s := &eureka2.State{}
+			if ok, instance := appCluster.deregister(target.InstanceID); ok {
+				log.Printf("No heartbeat was received for %s. Evicting: %s instance: %s\n", now.Sub(target.lastRenewal).Round(time.Second), appCluster.ID, instance)
+				st.changes.record(eureka2.DELETED, appCluster.ID, instance)
+			}
+		}
 
-	if caseInsensitiveContains(contentType, "application/xml") {
-		apps := &eureka2.Applications{}
-		err := xml.Unmarshal(rec.Body(), apps)
-		if err != nil {
-			panic(err)
+		if appCluster.noInstances() {
+			st.removeFakeApp(appCluster)
 		}
+	}
+}
 
-		s.Apps = apps
-		return s
+// Read the body of the request upfront so that it can be read again later.
+func bufferBody(r *http.Request) error {
 
-	} else if caseInsensitiveContains(contentType, "application/json") {
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
 
-		err := json.Unmarshal(rec.Body(), s)
+	if err != nil {
+		return badRequest("could not read the request body err: %s", err.Error())
+	}
 
-		if err != nil {
-			panic(err)
-		}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
 
-		return s
+	return nil
+}
+
+func readInstance(r *http.Request) (*eureka2.Instance, error) {
+
+	bytes, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	if err != nil {
+		return nil, badRequest("could not read the registration err: %s", err.Error())
 	}
 
-	panic(fmt.Errorf("could not deserialize body, unknown Content-Type: %s", contentType))
+	instance, err := eureka2.ParseRegistration(r.Header.Get("Content-Type"), bytes)
+
+	if err != nil {
+		return nil, badRequest("could not parse the registration err: %s", err.Error())
+	}
+
+	return instance, nil
 }
 
-func serialize(rec *httputil.HttpResponseRecorder, state *eureka2.State) []byte {
-	contentType := rec.Header().Get("Content-Type")
+func deserialize(rec *httputil.HttpResponseRecorder) (*registry, error) {
+	body, err := rec.Body()
 
-	if caseInsensitiveContains(contentType, "application/xml") {
-		bytes, err := xml.Marshal(state.Apps)
-		if err != nil {
-			panic(fmt.Errorf("could not marshal xml content %s", err.Error()))
-		}
+	if err != nil {
+		return nil, err
+	}
 
-		return bytes
+	apps, err := parseRegistry(rec.Header().Get("Content-Type"), body)
 
-	} else if caseInsensitiveContains(contentType, "application/json") {
-		bytes, err := json.Marshal(state)
-		if err != nil {
-			panic(fmt.Errorf("could not marshal json content %s", err.Error()))
-		}
+	if err != nil {
+		return nil, fmt.Errorf("could not deserialize body %s", err.Error())
+	}
 
-		return bytes
+	return apps, nil
+}
+
+func serialize(rec *httputil.HttpResponseRecorder, apps *registry) ([]byte, error) {
+	bytes, err := apps.encode()
+
+	if err != nil {
+		return nil, fmt.Errorf("could not serialize registry %s", err.Error())
+	}
+
+	if rec.Header().Get("Content-Encoding") == "gzip" {
+		return httputil.Gzip(bytes)
 	}
 
-	panic(fmt.Errorf("could not serialize unknown Content-Type: %s", contentType))
+	return bytes, nil
 }
 
 func caseInsensitiveContains(a, b string) bool {
@@ -0,0 +1,169 @@
+package fake
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	eureka2 "github.com/newestuser/eureka-proxy/lib/eureka"
+	"github.com/stretchr/testify/assert"
+)
+
+// Run with the race detector, example: go test -race ./lib/eureka/fake/
+func TestConcurrentRegistryAccess(t *testing.T) {
+	handler := RequestHandler([]*Application{SingleInstanceApp("static", "static:1", "127.0.0.1", "localhost", 8080)}, false, time.Second, emptyUpstream())
+
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			app := fmt.Sprintf("SERVICE-%d", i%3)
+			instanceID := fmt.Sprintf("localhost:%s:%d", app, 9000+i)
+
+			for j := 0; j < 20; j++ {
+				assert.Equal(t, http.StatusNoContent, serve(handler, http.MethodPost, "/eureka/apps/"+app, registration(app, instanceID, 9000+i)))
+				assert.Equal(t, http.StatusOK, serve(handler, http.MethodPut, "/eureka/apps/"+app+"/"+instanceID, ""))
+				assert.Equal(t, http.StatusOK, serve(handler, http.MethodGet, "/eureka/apps", ""))
+				assert.Equal(t, http.StatusOK, serve(handler, http.MethodGet, "/eureka/apps/delta", ""))
+				assert.Equal(t, http.StatusOK, serve(handler, http.MethodGet, "/eureka/vips/"+strings.ToLower(app), ""))
+				serve(handler, http.MethodPut, "/eureka/apps/"+app+"/"+instanceID+"/status?value=OUT_OF_SERVICE", "")
+				serve(handler, http.MethodGet, "/eureka/apps/"+app+"/"+instanceID, "")
+				serve(handler, http.MethodDelete, "/eureka/apps/"+app+"/"+instanceID, "")
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodGet, "/eureka/apps/STATIC", ""))
+}
+
+// Run with the race detector, example: go test -race ./lib/eureka/fake/
+func TestReloadWhileServing(t *testing.T) {
+	handler := RequestHandler(nil, false, time.Second, emptyUpstream())
+
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				handler.Reload([]*Application{SingleInstanceApp("static", "static:1", "127.0.0.1", "localhost", 8080)}, time.Duration(j)*time.Second)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				assert.Equal(t, http.StatusOK, serve(handler, http.MethodGet, "/eureka/apps", ""))
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestRegistrationWithInvalidBody(t *testing.T) {
+	handler := RequestHandler(nil, false, time.Second, emptyUpstream())
+
+	assert.Equal(t, http.StatusBadRequest, serve(handler, http.MethodPost, "/eureka/apps/FOO", "not json"))
+}
+
+func TestUnknownRegistryPassedThrough(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html>Bad Gateway</html>"))
+	})
+
+	handler := RequestHandler([]*Application{SingleInstanceApp("foo", "foo:1", "127.0.0.1", "localhost", 8080)}, false, time.Second, upstream)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/eureka/apps", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "<html>Bad Gateway</html>", rec.Body.String())
+}
+
+func TestHeartbeatOfEvictedInstance(t *testing.T) {
+	handler, clock := testRegistry(time.Minute)
+
+	serve(handler, http.MethodPost, "/eureka/apps/FOO", registration("FOO", "localhost:foo:9001", 9001))
+	clock.add(50 * time.Second)
+	serve(handler, http.MethodPost, "/eureka/apps/FOO", registration("FOO", "localhost:foo:9002", 9002))
+	clock.add(20 * time.Second)
+
+	// the first instance is evicted while the second one is still registered
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodPut, "/eureka/apps/FOO/localhost:foo:9001", ""))
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodPut, "/eureka/apps/FOO/localhost:foo:9002", ""))
+	assert.Equal(t, []string{"localhost:foo:9002"}, instanceIDs(fetchApps(t, handler, "/eureka/apps"), "FOO"))
+
+	clock.add(2 * time.Minute)
+
+	// none of the instances is left
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodPut, "/eureka/apps/FOO/localhost:foo:9002", ""))
+	assert.Empty(t, instanceIDs(fetchApps(t, handler, "/eureka/apps"), "FOO"))
+
+	// the client registers again with its registration
+	assert.Equal(t, http.StatusNoContent, serve(handler, http.MethodPost, "/eureka/apps/FOO", registration("FOO", "localhost:foo:9002", 9002)))
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodPut, "/eureka/apps/FOO/localhost:foo:9002", ""))
+
+	apps := fetchApps(t, handler, "/eureka/apps")
+	_, app := apps.ContainsApp("FOO")
+
+	assert.Equal(t, []string{"localhost:foo:9002"}, instanceIDs(apps, "FOO"))
+	assert.Equal(t, "foo", app.Instances[0].VIPAddress)
+}
+
+// A registry with the lease duration that uses a clock which only moves when it is told to.
+func testRegistry(leaseDuration time.Duration) (*state, *testClock) {
+	clock := &testClock{t: time.Now()}
+
+	st := RequestHandler(nil, false, leaseDuration, emptyUpstream()).(*state)
+	st.now = clock.now
+
+	return st, clock
+}
+
+func instanceIDs(apps *eureka2.Applications, appID string) []string {
+	ids := make([]string, 0)
+
+	if exists, app := apps.ContainsApp(appID); exists {
+		for _, instance := range app.Instances {
+			ids = append(ids, instance.InstanceID)
+		}
+	}
+
+	return ids
+}
+
+func emptyUpstream() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"applications":{"versions__delta":"1","apps__hashcode":"","application":[]}}`))
+	})
+}
+
+func registration(app, instanceID string, port int) string {
+	return fmt.Sprintf(`{"instance":{"instanceId":"%s","app":"%s","hostName":"localhost","ipAddr":"127.0.0.1","status":"UP","port":{"$":%d,"@enabled":"true"},"vipAddress":"%s"}}`,
+		instanceID, app, port, strings.ToLower(app))
+}
+
+func serve(handler http.Handler, method, path, body string) int {
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+
+	return rec.Code
+}
@@ -138,6 +138,17 @@ func (a *CommandArgs) First() *Arg {
 	return &Arg{val: a.args[0]}
 }
 
+// Retrieve all the program arguments in the order they were passed
+func (a *CommandArgs) All() []*Arg {
+	all := make([]*Arg, 0, len(a.args))
+
+	for _, val := range a.args {
+		all = append(all, &Arg{val: val})
+	}
+
+	return all
+}
+
 // Create a new StringFlag
 func String(name, value, usage string) *StringFlag {
 	f := flag.String(name, value, usage)
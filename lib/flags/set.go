@@ -52,7 +52,12 @@ func (fs *FlagSet) IntFlag(name string, value int, usage string) *IntFlag {
 
 func (fs *FlagSet) ParseArgs() *CommandArgs {
 
-	cmdArgs := os.Args[1:]
+	return fs.ParseCommandArgs(os.Args[1:])
+}
+
+// Parse the provided arguments, it can be used for the arguments of a sub command
+func (fs *FlagSet) ParseCommandArgs(cmdArgs []string) *CommandArgs {
+
 	if err := fs.FlagSet.Parse(cmdArgs); err != nil {
 		log.Fatal(err)
 	}
@@ -1,23 +1,35 @@
 package flags
 
 import (
+	"fmt"
 	"log"
 	"strconv"
 	"strings"
 )
 
 func ParseIdAndPort(serviceAndPort string) (string, int) {
+	serviceID, port, err := SplitIdAndPort(serviceAndPort)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return serviceID, port
+}
+
+// Split the service id and the port, example: foo-service:8081
+func SplitIdAndPort(serviceAndPort string) (string, int, error) {
 	parts := strings.Split(serviceAndPort, ":")
 
 	if len(parts) != 2 {
-		log.Fatalf("Fake service '%s' is in invalid format, example 'foo-service:8081'", serviceAndPort)
+		return "", 0, fmt.Errorf("Fake service '%s' is in invalid format, example 'foo-service:8081'", serviceAndPort)
 	}
 
 	serviceID := parts[0]
 	port, err := strconv.Atoi(parts[1])
 	if err != nil {
-		log.Fatalf("Fake service '%s' is in invalid format, example 'foo-service:8081'", serviceAndPort)
+		return "", 0, fmt.Errorf("Fake service '%s' is in invalid format, example 'foo-service:8081'", serviceAndPort)
 	}
 
-	return serviceID, port
+	return serviceID, port, nil
 }
@@ -13,6 +13,12 @@ func Recorder(w http.ResponseWriter) *HttpResponseRecorder {
 	return &HttpResponseRecorder{w: w, header: make(http.Header)}
 }
 
+// Create a new http response recorder that is not backed by an http.ResponseWriter.
+// It can be used to inspect a response that should not reach the client, the recorded content cannot be flushed.
+func DetachedRecorder() *HttpResponseRecorder {
+	return &HttpResponseRecorder{header: make(http.Header)}
+}
+
 // HttpResponseRecorder is wrapper of http.ResponseWriter
 type HttpResponseRecorder struct {
 	http.ResponseWriter
@@ -24,10 +30,18 @@ type HttpResponseRecorder struct {
 }
 
 func (rec *HttpResponseRecorder) Header() http.Header {
+	if rec.w == nil {
+		return rec.header
+	}
+
 	return rec.w.Header()
 }
 
 func (rec *HttpResponseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK // same as http.ResponseWriter, writing without a header implies 200
+	}
+
 	return rec.buff.Write(b)
 }
 
@@ -47,20 +61,42 @@ func (rec *HttpResponseRecorder) FlushWith(bytes []byte) {
 	rec.w.Write(bytes)
 }
 
+// Write the recorded headers, status and body to the provided http.ResponseWriter, it can be used with a detached recorder.
+func (rec *HttpResponseRecorder) CopyTo(w http.ResponseWriter) {
+	rec.CopyWith(w, rec.buff.Bytes())
+}
+
+// Write the recorded headers and status with the provided bytes to the provided http.ResponseWriter.
+func (rec *HttpResponseRecorder) CopyWith(w http.ResponseWriter, bytes []byte) {
+	for key, values := range rec.Header() {
+		w.Header()[key] = append([]string(nil), values...)
+	}
+
+	w.Header().Del("Content-Length") // remove this header in order to write a proper content-length value
+	w.WriteHeader(rec.status)
+	w.Write(bytes)
+}
+
 // A convenient method for extracting the recorded bytes.
-// Note that if the content is encoded it will be decoded.
-func (rec *HttpResponseRecorder) Body() []byte {
+// Note that if the content is encoded it will be decoded, an error is returned when it cannot be decoded.
+func (rec *HttpResponseRecorder) Body() ([]byte, error) {
 	return rec.gunzipBytes()
 }
 
 // A convenient method for extracting the recorded bytes in a string format.
-// Note that if the content is encoded it will be decoded.
+// Note that if the content is encoded it will be decoded, the content is returned as it is when it cannot be decoded.
 func (rec *HttpResponseRecorder) BodyString() string {
 	if rec.buff.Len() == 0 {
 		return ""
 	}
 
-	return string(rec.gunzipBytes())
+	body, err := rec.gunzipBytes()
+
+	if err != nil {
+		return rec.buff.String()
+	}
+
+	return string(body)
 }
 
 // Return the recorded status.
@@ -68,33 +104,33 @@ func (rec *HttpResponseRecorder) Status() int {
 	return rec.status
 }
 
-func (rec *HttpResponseRecorder) gunzipBytes() []byte {
+func (rec *HttpResponseRecorder) gunzipBytes() ([]byte, error) {
 
 	if rec.Header().Get("Content-Encoding") == "gzip" {
 
 		return Gunzip(rec.buff.Bytes())
 	}
 
-	return rec.buff.Bytes()
+	return rec.buff.Bytes(), nil
 }
 
 // Extract the gzip bytes.
-func Gunzip(v []byte) []byte {
+func Gunzip(v []byte) ([]byte, error) {
 	reader, err := gzip.NewReader(bytes.NewReader(v))
 	if err != nil {
-		panic(fmt.Errorf("could not create gzip reader err: %s", err.Error()))
+		return nil, fmt.Errorf("could not create gzip reader err: %s", err.Error())
 	}
 
 	unziped, err := ioutil.ReadAll(reader)
 	if err != nil {
-		panic(fmt.Errorf("could not read gzip content: %s", err.Error()))
+		return nil, fmt.Errorf("could not read gzip content: %s", err.Error())
 	}
 
-	return unziped
+	return unziped, nil
 }
 
 // Gzip the provided bytes.
-func Gzip(v []byte) []byte {
+func Gzip(v []byte) ([]byte, error) {
 	buff := &bytes.Buffer{}
 
 	w := gzip.NewWriter(buff)
@@ -102,12 +138,12 @@ func Gzip(v []byte) []byte {
 	_, err := w.Write(v)
 
 	if err != nil {
-		panic(fmt.Errorf("could not write gzip content err: %s", err.Error()))
+		return nil, fmt.Errorf("could not write gzip content err: %s", err.Error())
 	}
 
 	if err := w.Close(); err != nil {
-		panic(fmt.Errorf("could not close gzip writer err: %s", err.Error()))
+		return nil, fmt.Errorf("could not close gzip writer err: %s", err.Error())
 	}
 
-	return buff.Bytes()
+	return buff.Bytes(), nil
 }
@@ -6,10 +6,12 @@ import (
 	"os"
 )
 
+// Resolve the ip address used for outbound traffic.
+// When the machine is offline the loopback address is returned.
 func OutboundIP() net.IP {
 	conn, err := net.Dial("udp", "8.8.8.8:80")
 	if err != nil {
-		panic(err)
+		return net.IPv4(127, 0, 0, 1)
 	}
 	defer conn.Close()
 
@@ -0,0 +1,84 @@
+package reload
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Watch the configuration file and apply it again when it changes or when the process receives SIGHUP.
+// The file is checked for changes with the interval, zero disables the checks and only SIGHUP is handled.
+// When the configuration cannot be applied the error is logged and the last good configuration stays in use.
+func Watch(path string, interval time.Duration, apply func(bytes []byte) error) {
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	var ticks <-chan time.Time
+
+	if interval > 0 {
+		ticks = time.NewTicker(interval).C
+	}
+
+	w := &watcher{path: path, apply: apply}
+	w.modified()
+
+	go func() {
+		for {
+			select {
+			case <-signals:
+				log.Printf("SIGHUP received, reloading %s\n", path)
+				w.reload()
+			case <-ticks:
+				if w.modified() {
+					log.Printf("A change was detected, reloading %s\n", path)
+					w.reload()
+				}
+			}
+		}
+	}()
+}
+
+type watcher struct {
+	path  string
+	apply func(bytes []byte) error
+
+	modTime time.Time
+	size    int64
+}
+
+// Check if the file changed since the last check.
+func (w *watcher) modified() bool {
+	info, err := os.Stat(w.path)
+
+	if err != nil {
+		// the file might be in the middle of being replaced, it is checked again on the next tick
+		return false
+	}
+
+	changed := !info.ModTime().Equal(w.modTime) || info.Size() != w.size
+
+	w.modTime = info.ModTime()
+	w.size = info.Size()
+
+	return changed
+}
+
+func (w *watcher) reload() {
+	bytes, err := ioutil.ReadFile(w.path)
+
+	if err != nil {
+		log.Printf("Could not read %s, keeping the last good configuration err: %s\n", w.path, err.Error())
+		return
+	}
+
+	if err := w.apply(bytes); err != nil {
+		log.Printf("Invalid configuration %s, keeping the last good configuration err: %s\n", w.path, err.Error())
+		return
+	}
+
+	log.Printf("Reloaded %s\n", w.path)
+}
@@ -0,0 +1,136 @@
+package reload
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchAppliesChanges(t *testing.T) {
+	path := tempConfig(t, "first")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config := &lastGood{}
+	Watch(path, 10*time.Millisecond, config.apply)
+
+	// the configuration the program started with is not applied again
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "", config.get())
+
+	rewrite(t, path, "second")
+	assert.Equal(t, "second", config.await("second"))
+
+	rewrite(t, path, "the third")
+	assert.Equal(t, "the third", config.await("the third"))
+}
+
+func TestWatchKeepsLastGoodConfiguration(t *testing.T) {
+	path := tempConfig(t, "first")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config := &lastGood{}
+	Watch(path, 10*time.Millisecond, config.apply)
+
+	rewrite(t, path, "second")
+	assert.Equal(t, "second", config.await("second"))
+
+	rewrite(t, path, "invalid")
+	assert.Equal(t, 1, config.awaitRejected(1))
+	assert.Equal(t, "second", config.get())
+
+	// a missing file is retried on the next check
+	assert.Nil(t, os.Remove(path))
+	time.Sleep(50 * time.Millisecond)
+	rewrite(t, path, "fourth")
+
+	assert.Equal(t, "fourth", config.await("fourth"))
+}
+
+func TestWatchReloadsOnSighup(t *testing.T) {
+	path := tempConfig(t, "first")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config := &lastGood{}
+	Watch(path, 0, config.apply)
+
+	assert.Nil(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	assert.Equal(t, "first", config.await("first"))
+}
+
+func tempConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "reload")
+	assert.Nil(t, err)
+
+	path := filepath.Join(dir, "config.yml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+
+	return path
+}
+
+// Replace the file at once like the editors do, a file that is written in place can be read while it is still empty.
+func rewrite(t *testing.T, path, content string) {
+	tmp := path + ".tmp"
+
+	assert.Nil(t, ioutil.WriteFile(tmp, []byte(content), 0644))
+	assert.Nil(t, os.Rename(tmp, path))
+}
+
+// A configuration that rejects the files containing "invalid" and keeps the last one it applied.
+type lastGood struct {
+	mu       sync.Mutex
+	content  string
+	rejected int
+}
+
+func (c *lastGood) apply(bytes []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if string(bytes) == "invalid" {
+		c.rejected++
+		return fmt.Errorf("invalid configuration")
+	}
+
+	c.content = string(bytes)
+
+	return nil
+}
+
+func (c *lastGood) get() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.content
+}
+
+// Wait for the content to be applied, return the applied content once it is or after a second.
+func (c *lastGood) await(content string) string {
+	for i := 0; i < 100 && c.get() != content; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return c.get()
+}
+
+func (c *lastGood) awaitRejected(count int) int {
+	for i := 0; i < 100; i++ {
+		c.mu.Lock()
+		rejected := c.rejected
+		c.mu.Unlock()
+
+		if rejected >= count {
+			return rejected
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return count - 1
+}
@@ -0,0 +1,206 @@
+package reverse
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/newestuser/eureka-proxy/lib/logging"
+)
+
+// The strategies that pick the target of a request.
+const (
+	RoundRobin       = "round-robin"
+	Random           = "random"
+	LeastConnections = "least-connections"
+	Weighted         = "weighted"
+)
+
+// The number of failures in a row after which a target is taken out of the pool, and for how long.
+const (
+	maxFailures    = 3
+	ejectionPeriod = 10 * time.Second
+)
+
+// A target of a load balanced route, the weight is only used by the weighted strategy.
+type Target struct {
+	URL    *url.URL
+	Weight int
+}
+
+// A handler that spreads the requests across the targets with the strategy. The targets are tracked passively,
+// a target that could not be reached or responded with 502, 503 or 504 several times in a row is taken out of the pool
+// for a while. When all the targets are out of the pool the requests are spread across all of them.
+type balancerHandler struct {
+	logger   logging.Logger
+	strategy string
+	now      func() time.Time
+
+	mu      sync.Mutex
+	targets []*balancedTarget
+	next    int
+	random  *rand.Rand
+}
+
+type balancedTarget struct {
+	url    *url.URL
+	weight int
+	proxy  *httputil.ReverseProxy
+
+	// the fields are guarded by the mutex of the handler
+	active       int
+	failures     int
+	ejectedUntil time.Time
+	// the current weight of the smooth weighted round-robin
+	current int
+}
+
+func newBalancerHandler(logger logging.Logger, strategy string, targets []*Target, transport http.RoundTripper, rewriteHost bool) (*balancerHandler, error) {
+
+	switch strategy {
+	case "":
+		strategy = RoundRobin
+	case RoundRobin, Random, LeastConnections, Weighted:
+	default:
+		return nil, fmt.Errorf("unknown balancing: '%v' expected one of round-robin, random, least-connections or weighted", strategy)
+	}
+
+	handler := &balancerHandler{logger: logger, strategy: strategy, now: time.Now, random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+	for _, t := range targets {
+		weight := t.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+
+		target := &balancedTarget{url: t.URL, weight: weight, proxy: newSingleHostProxy(t.URL, transport, rewriteHost)}
+
+		target.proxy.ModifyResponse = func(resp *http.Response) error {
+			if isUnavailable(resp.StatusCode) {
+				handler.markFailed(target, fmt.Errorf("the target responded with status: %d", resp.StatusCode))
+			} else {
+				handler.markSucceeded(target)
+			}
+
+			return nil
+		}
+
+		target.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			// the client that went away says nothing about the health of the target
+			if r.Context().Err() == nil {
+				handler.markFailed(target, err)
+			}
+
+			w.WriteHeader(http.StatusBadGateway)
+		}
+
+		handler.targets = append(handler.targets, target)
+	}
+
+	return handler, nil
+}
+
+func (h *balancerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	target := h.pick()
+
+	defer func() {
+		h.mu.Lock()
+		target.active--
+		h.mu.Unlock()
+	}()
+
+	target.proxy.ServeHTTP(w, r)
+}
+
+// Pick the target of a request with the strategy and count the request as active until it completes.
+func (h *balancerHandler) pick() *balancedTarget {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	pool := h.pool()
+	var target *balancedTarget
+
+	switch h.strategy {
+	case Random:
+		target = pool[h.random.Intn(len(pool))]
+	case LeastConnections:
+		// the scan starts from the next target every time so that the ties are broken round-robin
+		start := h.next % len(pool)
+		h.next++
+
+		for i := range pool {
+			candidate := pool[(start+i)%len(pool)]
+
+			if target == nil || candidate.active < target.active {
+				target = candidate
+			}
+		}
+	case Weighted:
+		// smooth weighted round-robin, the targets are picked in proportion to their weights without bursts
+		total := 0
+		for _, candidate := range pool {
+			candidate.current += candidate.weight
+			total += candidate.weight
+
+			if target == nil || candidate.current > target.current {
+				target = candidate
+			}
+		}
+		target.current -= total
+	default:
+		target = pool[h.next%len(pool)]
+		h.next++
+	}
+
+	target.active++
+
+	return target
+}
+
+// The targets that are in the pool, all the targets when none of them is.
+func (h *balancerHandler) pool() []*balancedTarget {
+	now := h.now()
+	pool := make([]*balancedTarget, 0, len(h.targets))
+
+	for _, target := range h.targets {
+		if !now.Before(target.ejectedUntil) {
+			pool = append(pool, target)
+		}
+	}
+
+	if len(pool) == 0 {
+		return h.targets
+	}
+
+	return pool
+}
+
+func (h *balancerHandler) markFailed(target *balancedTarget, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	target.failures++
+
+	// a target that fails again after it returned to the pool is taken out right away
+	if target.failures >= maxFailures {
+		h.logger.ErrF("the target %s failed %d times in a row, it is taken out of the pool for %s err: %s", target.url, target.failures, ejectionPeriod, err.Error())
+		target.ejectedUntil = h.now().Add(ejectionPeriod)
+	}
+}
+
+func (h *balancerHandler) markSucceeded(target *balancedTarget) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if target.failures >= maxFailures {
+		h.logger.InfoF("The target %s is back in the pool\n", target.url)
+	}
+
+	target.failures = 0
+	target.ejectedUntil = time.Time{}
+}
@@ -0,0 +1,179 @@
+package reverse
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newestuser/eureka-proxy/lib/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundRobin(t *testing.T) {
+	foo, fooURL := target("foo")
+	defer foo.Close()
+	bar, barURL := target("bar")
+	defer bar.Close()
+
+	proxy := balancedProxy(t, RoundRobin, &Target{URL: fooURL}, &Target{URL: barURL})
+
+	assert.Equal(t, map[string]int{"foo": 3, "bar": 3}, spread(proxy, 6))
+}
+
+func TestWeighted(t *testing.T) {
+	foo, fooURL := target("foo")
+	defer foo.Close()
+	bar, barURL := target("bar")
+	defer bar.Close()
+
+	proxy := balancedProxy(t, Weighted, &Target{URL: fooURL, Weight: 3}, &Target{URL: barURL, Weight: 1})
+
+	assert.Equal(t, map[string]int{"foo": 6, "bar": 2}, spread(proxy, 8))
+}
+
+func TestRandom(t *testing.T) {
+	foo, fooURL := target("foo")
+	defer foo.Close()
+	bar, barURL := target("bar")
+	defer bar.Close()
+
+	proxy := balancedProxy(t, Random, &Target{URL: fooURL}, &Target{URL: barURL})
+
+	counts := spread(proxy, 100)
+
+	assert.Equal(t, 100, counts["foo"]+counts["bar"])
+	assert.True(t, counts["foo"] > 0 && counts["bar"] > 0)
+}
+
+func TestLeastConnections(t *testing.T) {
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	fast, fastURL := target("fast")
+	defer fast.Close()
+
+	slowURL, _ := url.Parse(slow.URL)
+	proxy := balancedProxy(t, LeastConnections, &Target{URL: slowURL}, &Target{URL: fastURL})
+
+	// the first request is picked by the slow target and stays active
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		spread(proxy, 1)
+	}()
+
+	for i := 0; i < 100 && activeRequests(proxy) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Equal(t, map[string]int{"fast": 3}, spread(proxy, 3))
+
+	close(release)
+	wg.Wait()
+}
+
+func TestLeastConnectionsBreaksTiesRoundRobin(t *testing.T) {
+	foo, fooURL := target("foo")
+	defer foo.Close()
+	bar, barURL := target("bar")
+	defer bar.Close()
+
+	proxy := balancedProxy(t, LeastConnections, &Target{URL: fooURL}, &Target{URL: barURL})
+
+	assert.Equal(t, map[string]int{"foo": 5, "bar": 5}, spread(proxy, 10))
+}
+
+func TestCancelledRequestsDoNotTakeTargetOutOfPool(t *testing.T) {
+	foo, fooURL := target("foo")
+	defer foo.Close()
+
+	proxy := balancedProxy(t, RoundRobin, &Target{URL: fooURL})
+
+	for i := 0; i < maxFailures+1; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/", nil).WithContext(ctx))
+	}
+
+	proxy.mu.Lock()
+	failures := proxy.targets[0].failures
+	proxy.mu.Unlock()
+
+	assert.Equal(t, 0, failures)
+}
+
+func TestFailingTargetIsTakenOutOfPool(t *testing.T) {
+	foo, fooURL := target("foo")
+	defer foo.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	downURL, _ := url.Parse(down.URL)
+	proxy := balancedProxy(t, RoundRobin, &Target{URL: fooURL}, &Target{URL: downURL})
+
+	assert.Equal(t, map[string]int{"foo": 3, "503": 3}, spread(proxy, 6))
+	assert.Equal(t, map[string]int{"foo": 4}, spread(proxy, 4))
+
+	// the target returns to the pool once the ejection period passes
+	proxy.now = func() time.Time { return time.Now().Add(ejectionPeriod) }
+
+	assert.Equal(t, map[string]int{"foo": 1, "503": 1}, spread(proxy, 2))
+	assert.Equal(t, map[string]int{"foo": 2}, spread(proxy, 2))
+}
+
+func TestUnknownBalancing(t *testing.T) {
+	_, err := newBalancerHandler(logging.NewLevelLogger(false, false), "fastest", nil, http.DefaultTransport, false)
+
+	assert.NotNil(t, err)
+}
+
+func balancedProxy(t *testing.T, strategy string, targets ...*Target) *balancerHandler {
+	handler, err := newBalancerHandler(logging.NewLevelLogger(false, false), strategy, targets, http.DefaultTransport, false)
+	assert.Nil(t, err)
+
+	return handler
+}
+
+// Send the requests one after another and count the responses of every target.
+func spread(proxy http.Handler, requests int) map[string]int {
+	counts := make(map[string]int)
+
+	for i := 0; i < requests; i++ {
+		r := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, r)
+
+		if rec.Code != http.StatusOK {
+			counts[strconv.Itoa(rec.Code)]++
+		} else {
+			counts[rec.Body.String()]++
+		}
+	}
+
+	return counts
+}
+
+func activeRequests(proxy *balancerHandler) int {
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+
+	active := 0
+	for _, target := range proxy.targets {
+		active += target.active
+	}
+
+	return active
+}
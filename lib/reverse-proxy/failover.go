@@ -0,0 +1,196 @@
+package reverse
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/newestuser/eureka-proxy/lib/logging"
+)
+
+// The time after which an unreachable target is checked again.
+const healthCheckInterval = 10 * time.Second
+
+// A handler that proxies to the first reachable target, the targets are tried in order.
+// Targets that cannot be reached are skipped until a health check finds them reachable again,
+// the requests are retried on the next target so the clients do not notice that a target is down.
+type failoverHandler struct {
+	logger  logging.Logger
+	targets []*failoverTarget
+	// the client of the health checks, it connects to the targets the same way the proxy does
+	client *http.Client
+	now    func() time.Time
+}
+
+type failoverTarget struct {
+	url   *url.URL
+	proxy *httputil.ReverseProxy
+
+	mu        sync.Mutex
+	healthy   bool
+	checking  bool
+	nextCheck time.Time
+}
+
+// The outcome of a single attempt to proxy the request.
+type attemptKey struct{}
+
+type attempt struct {
+	err error
+}
+
+func newFailoverHandler(logger logging.Logger, targetURLs []*url.URL, transport http.RoundTripper, rewriteHost bool) *failoverHandler {
+	handler := &failoverHandler{logger: logger, client: &http.Client{Timeout: 5 * time.Second, Transport: transport}, now: time.Now}
+
+	for _, targetURL := range targetURLs {
+		target := &failoverTarget{url: targetURL, proxy: newSingleHostProxy(targetURL, transport, rewriteHost), healthy: true}
+
+		target.proxy.ModifyResponse = func(resp *http.Response) error {
+			if isUnavailable(resp.StatusCode) {
+				return fmt.Errorf("the target responded with status: %d", resp.StatusCode)
+			}
+
+			return nil
+		}
+
+		target.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			// nothing is written so that the request can be retried on the next target
+			r.Context().Value(attemptKey{}).(*attempt).err = err
+		}
+
+		handler.targets = append(handler.targets, target)
+	}
+
+	return handler
+}
+
+func (h *failoverHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	// the body is kept so that it can be sent again to the next target
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+
+	if err != nil {
+		h.logger.ErrF("could not read the request body err: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for _, target := range h.ordered() {
+		outcome := &attempt{}
+		attemptReq := r.WithContext(context.WithValue(r.Context(), attemptKey{}, outcome))
+		attemptReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		target.proxy.ServeHTTP(w, attemptReq)
+
+		if outcome.err == nil {
+			h.markHealthy(target)
+			return
+		}
+
+		if r.Context().Err() != nil {
+			// the client is gone, there is no one to retry for
+			return
+		}
+
+		h.markUnhealthy(target, outcome.err)
+	}
+
+	h.logger.ErrF("none of the targets could be reached: %s %s", r.Method, r.URL.Path)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// The healthy targets in order followed by the unhealthy ones as a last resort.
+func (h *failoverHandler) ordered() []*failoverTarget {
+	healthy := make([]*failoverTarget, 0, len(h.targets))
+	unhealthy := make([]*failoverTarget, 0)
+
+	for _, target := range h.targets {
+		if target.isHealthy() {
+			healthy = append(healthy, target)
+		} else {
+			unhealthy = append(unhealthy, target)
+			h.checkLater(target)
+		}
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+func (h *failoverHandler) markHealthy(target *failoverTarget) {
+	target.mu.Lock()
+	defer target.mu.Unlock()
+
+	if !target.healthy {
+		h.logger.InfoF("The target %s is reachable again\n", target.url)
+	}
+
+	target.healthy = true
+}
+
+func (h *failoverHandler) markUnhealthy(target *failoverTarget, err error) {
+	target.mu.Lock()
+	defer target.mu.Unlock()
+
+	if target.healthy {
+		h.logger.ErrF("the target %s cannot be reached, trying the next one err: %s", target.url, err.Error())
+		target.nextCheck = h.now().Add(healthCheckInterval)
+	}
+
+	target.healthy = false
+}
+
+// Check the health of the unhealthy target in the background once the check interval passed.
+func (h *failoverHandler) checkLater(target *failoverTarget) {
+	target.mu.Lock()
+	defer target.mu.Unlock()
+
+	if target.checking || h.now().Before(target.nextCheck) {
+		return
+	}
+
+	target.checking = true
+
+	go func() {
+		healthy := h.isReachable(target.url)
+
+		if healthy {
+			h.markHealthy(target)
+		}
+
+		target.mu.Lock()
+		target.checking = false
+		target.nextCheck = h.now().Add(healthCheckInterval)
+		target.mu.Unlock()
+	}()
+}
+
+func (target *failoverTarget) isHealthy() bool {
+	target.mu.Lock()
+	defer target.mu.Unlock()
+
+	return target.healthy
+}
+
+// A target is reachable when it responds with a status that does not indicate that it is unavailable.
+func (h *failoverHandler) isReachable(target *url.URL) bool {
+	resp, err := h.client.Get(target.String())
+
+	if err != nil {
+		return false
+	}
+
+	resp.Body.Close()
+
+	return !isUnavailable(resp.StatusCode)
+}
+
+func isUnavailable(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
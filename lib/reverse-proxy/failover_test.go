@@ -0,0 +1,212 @@
+package reverse
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newestuser/eureka-proxy/lib/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailoverRetriesUnavailableTargets(t *testing.T) {
+	second, secondURL := target("second")
+	defer second.Close()
+
+	for _, status := range []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout} {
+		first := newSwitchableTarget(status)
+		defer first.server.Close()
+
+		proxy := failoverProxy(first.url, secondURL)
+
+		code, body := post(proxy, "")
+
+		assert.Equal(t, http.StatusOK, code, strconv.Itoa(status))
+		assert.Equal(t, "second", body, strconv.Itoa(status))
+	}
+}
+
+func TestFailoverRetriesUnreachableTarget(t *testing.T) {
+	first, firstURL := target("first")
+	first.Close()
+
+	second, secondURL := target("second")
+	defer second.Close()
+
+	code, body := post(failoverProxy(firstURL, secondURL), "")
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "second", body)
+}
+
+func TestFailoverReplaysBody(t *testing.T) {
+	first := newSwitchableTarget(http.StatusServiceUnavailable)
+	defer first.server.Close()
+
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer echo.Close()
+
+	echoURL, _ := url.Parse(echo.URL)
+
+	code, body := post(failoverProxy(first.url, echoURL), `{"name":"foo"}`)
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"name":"foo"}`, body)
+	assert.Equal(t, []string{`{"name":"foo"}`}, first.bodies())
+}
+
+func TestFailoverSkipsUnhealthyTargets(t *testing.T) {
+	first := newSwitchableTarget(http.StatusServiceUnavailable)
+	defer first.server.Close()
+
+	second, secondURL := target("second")
+	defer second.Close()
+
+	proxy := failoverProxy(first.url, secondURL)
+
+	for i := 0; i < 5; i++ {
+		_, body := post(proxy, "")
+
+		assert.Equal(t, "second", body)
+	}
+
+	// the first target is only tried until it fails once
+	assert.Len(t, first.bodies(), 1)
+}
+
+func TestFailoverTriesUnhealthyTargetsAsLastResort(t *testing.T) {
+	first := newSwitchableTarget(http.StatusServiceUnavailable)
+	defer first.server.Close()
+
+	second := newSwitchableTarget(http.StatusServiceUnavailable)
+	defer second.server.Close()
+
+	proxy := failoverProxy(first.url, second.url)
+
+	code, _ := post(proxy, "")
+	assert.Equal(t, http.StatusBadGateway, code)
+
+	second.setStatus(http.StatusOK)
+
+	code, body := post(proxy, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, strconv.Itoa(http.StatusOK), body)
+}
+
+func TestFailoverRechecksUnhealthyTargets(t *testing.T) {
+	first := newSwitchableTarget(http.StatusServiceUnavailable)
+	defer first.server.Close()
+
+	second, secondURL := target("second")
+	defer second.Close()
+
+	proxy := failoverProxy(first.url, secondURL)
+
+	clock := &testClock{t: time.Now()}
+	proxy.now = clock.now
+
+	post(proxy, "")
+	first.setStatus(http.StatusOK)
+
+	// the target is not checked before the interval passes
+	post(proxy, "")
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, proxy.targets[0].isHealthy())
+
+	clock.add(healthCheckInterval)
+	post(proxy, "")
+
+	for i := 0; i < 100 && !proxy.targets[0].isHealthy(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, body := post(proxy, "")
+
+	assert.Equal(t, strconv.Itoa(http.StatusOK), body)
+}
+
+func failoverProxy(targets ...*url.URL) *failoverHandler {
+	return newFailoverHandler(logging.NewLevelLogger(false, false), targets, http.DefaultTransport, false)
+}
+
+func post(proxy http.Handler, body string) (int, string) {
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+
+	return rec.Code, rec.Body.String()
+}
+
+// A target that responds with the status it is told to and its status in the body,
+// it keeps the bodies of the proxied requests which are all sent with POST unlike the health checks.
+type switchableTarget struct {
+	server *httptest.Server
+	url    *url.URL
+
+	mu       sync.Mutex
+	status   int
+	received []string
+}
+
+func newSwitchableTarget(status int) *switchableTarget {
+	target := &switchableTarget{status: status}
+
+	target.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		target.mu.Lock()
+		if r.Method == http.MethodPost {
+			target.received = append(target.received, string(body))
+		}
+		status := target.status
+		target.mu.Unlock()
+
+		w.WriteHeader(status)
+		w.Write([]byte(strconv.Itoa(status)))
+	}))
+
+	target.url, _ = url.Parse(target.server.URL)
+
+	return target
+}
+
+func (t *switchableTarget) setStatus(status int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status = status
+}
+
+func (t *switchableTarget) bodies() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]string{}, t.received...)
+}
+
+type testClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *testClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.t
+}
+
+func (c *testClock) add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.t = c.t.Add(d)
+}
@@ -0,0 +1,165 @@
+package header
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// The actions of the header rules.
+const (
+	Add    = "add"
+	Set    = "set"
+	Remove = "remove"
+	Rename = "rename"
+)
+
+// The placeholders in the values, {env:NAME} is replaced with the environment variable so that tokens do not have to be
+// kept in the configuration file.
+var placeholderPattern = regexp.MustCompile(`\{(client_ip|host|method|path|env:[A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// A rule that changes a header, the value is the new name of the header for the rename rules.
+type Rule struct {
+	Action string
+	Name   string
+	Value  string
+}
+
+// Create a rule and check that it is complete.
+func NewRule(action, name, value string) (*Rule, error) {
+
+	if name == "" {
+		return nil, fmt.Errorf("the header of the %s rule is missing", action)
+	}
+
+	switch action {
+	case Add, Set, Remove:
+	case Rename:
+		if value == "" {
+			return nil, fmt.Errorf("the new name of the header %s is missing", name)
+		}
+	default:
+		return nil, fmt.Errorf("unknown header action: '%v' expected one of add, set, remove or rename", action)
+	}
+
+	return &Rule{Action: action, Name: name, Value: value}, nil
+}
+
+func (rule *Rule) String() string {
+	if rule.Action == Remove {
+		return fmt.Sprintf("%s %s", rule.Action, rule.Name)
+	}
+
+	return fmt.Sprintf("%s %s: %s", rule.Action, rule.Name, rule.Value)
+}
+
+// Apply the rules in order to the headers, the placeholders in the values are resolved from the request of the client.
+func Apply(rules []*Rule, h http.Header, r *http.Request) {
+
+	for _, rule := range rules {
+		switch rule.Action {
+		case Add:
+			h.Add(rule.Name, resolve(rule.Value, r))
+		case Set:
+			h.Set(rule.Name, resolve(rule.Value, r))
+		case Remove:
+			h.Del(rule.Name)
+		case Rename:
+			if values := h.Values(rule.Name); len(values) > 0 {
+				h.Del(rule.Name)
+				h[http.CanonicalHeaderKey(rule.Value)] = values
+			}
+		}
+	}
+}
+
+func resolve(value string, r *http.Request) string {
+
+	return placeholderPattern.ReplaceAllStringFunc(value, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+
+		switch name {
+		case "client_ip":
+			return clientIP(r)
+		case "host":
+			return r.Host
+		case "method":
+			return r.Method
+		case "path":
+			return r.URL.Path
+		}
+
+		return os.Getenv(strings.TrimPrefix(name, "env:"))
+	})
+}
+
+func clientIP(r *http.Request) string {
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// Create a handler that applies the rules to the headers of the request before it is passed to the chain
+// and to the headers of the response before they are written.
+func NewHandler(requestRules, responseRules []*Rule, chain http.Handler) http.Handler {
+
+	return &headerHandler{requestRules: requestRules, responseRules: responseRules, chain: chain}
+}
+
+type headerHandler struct {
+	requestRules  []*Rule
+	responseRules []*Rule
+	chain         http.Handler
+}
+
+func (h *headerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	Apply(h.requestRules, r.Header, r)
+
+	if len(h.responseRules) > 0 {
+		w = &headerWriter{ResponseWriter: w, apply: func(header http.Header) { Apply(h.responseRules, header, r) }}
+	}
+
+	h.chain.ServeHTTP(w, r)
+}
+
+// A writer that applies the rules to the headers right before they are written.
+type headerWriter struct {
+	http.ResponseWriter
+	apply   func(http.Header)
+	applied bool
+}
+
+func (w *headerWriter) WriteHeader(status int) {
+	w.applyOnce()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerWriter) Write(bytes []byte) (int, error) {
+	w.applyOnce()
+	return w.ResponseWriter.Write(bytes)
+}
+
+// Flush the response so that the streamed responses of the target are not buffered.
+func (w *headerWriter) Flush() {
+	w.applyOnce()
+
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *headerWriter) applyOnce() {
+	if !w.applied {
+		w.applied = true
+		w.apply(w.Header())
+	}
+}
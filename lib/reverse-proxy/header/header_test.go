@@ -0,0 +1,86 @@
+package header
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestRules(t *testing.T) {
+	os.Setenv("HEADER_TEST_TOKEN", "secret")
+	defer os.Unsetenv("HEADER_TEST_TOKEN")
+
+	rules := mustRules(t,
+		&Rule{Add, "X-Forwarded-Client", "{client_ip}"},
+		&Rule{Set, "Authorization", "Bearer {env:HEADER_TEST_TOKEN}"},
+		&Rule{Remove, "Cookie", ""},
+		&Rule{Rename, "X-Old", "X-New"},
+		&Rule{Set, "X-Original", "{method} {host}{path}"},
+	)
+
+	var received http.Header
+	handler := NewHandler(rules, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "http://foo.net/api/bar", nil)
+	r.RemoteAddr = "10.0.0.5:51234"
+	r.Header.Set("Authorization", "Basic Zm9v")
+	r.Header.Set("Cookie", "session=1")
+	r.Header.Add("X-Old", "1")
+	r.Header.Add("X-Old", "2")
+
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.Equal(t, "10.0.0.5", received.Get("X-Forwarded-Client"))
+	assert.Equal(t, []string{"Bearer secret"}, received.Values("Authorization"))
+	assert.Empty(t, received.Get("Cookie"))
+	assert.Empty(t, received.Get("X-Old"))
+	assert.Equal(t, []string{"1", "2"}, received.Values("X-New"))
+	assert.Equal(t, "GET foo.net/api/bar", received.Get("X-Original"))
+}
+
+func TestResponseRules(t *testing.T) {
+	rules := mustRules(t,
+		&Rule{Remove, "Server", ""},
+		&Rule{Add, "X-Served-For", "{client_ip}"},
+	)
+
+	handler := NewHandler(nil, rules, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "backend/1.0")
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.5:51234"
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, r)
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Empty(t, rec.Header().Get("Server"))
+	assert.Equal(t, "10.0.0.5", rec.Header().Get("X-Served-For"))
+}
+
+func TestInvalidRules(t *testing.T) {
+	_, err := NewRule("replace", "X-Foo", "bar")
+	assert.NotNil(t, err)
+
+	_, err = NewRule(Rename, "X-Foo", "")
+	assert.NotNil(t, err)
+
+	_, err = NewRule(Set, "", "bar")
+	assert.NotNil(t, err)
+}
+
+func mustRules(t *testing.T, rules ...*Rule) []*Rule {
+	for _, rule := range rules {
+		_, err := NewRule(rule.Action, rule.Name, rule.Value)
+		assert.Nil(t, err)
+	}
+
+	return rules
+}
@@ -0,0 +1,97 @@
+package reverse
+
+import (
+	"sort"
+	"strings"
+)
+
+// Order the routes in which they are matched: the routes with a higher priority first, then the routes with the longer
+// path prefix and then the routes with more matchers. The routes that are still equal keep their order.
+func sortRoutes(routes []*RouteConfig) []*RouteConfig {
+
+	sorted := append([]*RouteConfig(nil), routes...)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+
+		if len(a.Route) != len(b.Route) {
+			return len(a.Route) > len(b.Route)
+		}
+
+		return a.matcherCount() > b.matcherCount()
+	})
+
+	return sorted
+}
+
+func (r *RouteConfig) matcherCount() int {
+
+	count := len(r.Methods) + len(r.Headers) + len(r.Queries)
+
+	if r.Host != "" {
+		count++
+	}
+
+	return count
+}
+
+// Check if the route matches every request the other route matches, the other route never receives a request
+// when it is matched after the route.
+func (r *RouteConfig) shadows(other *RouteConfig) bool {
+
+	if !strings.HasPrefix(other.Route, r.Route) {
+		return false
+	}
+
+	if r.Host != "" && r.Host != other.Host {
+		return false
+	}
+
+	if len(r.Methods) > 0 && !containsAll(r.Methods, other.Methods) {
+		return false
+	}
+
+	return matchesAll(r.Headers, other.Headers) && matchesAll(r.Queries, other.Queries)
+}
+
+// Check if the methods contain all the other methods, an empty list of other methods matches any method.
+func containsAll(methods, others []string) bool {
+
+	if len(others) == 0 {
+		return false
+	}
+
+	for _, other := range others {
+		found := false
+
+		for _, method := range methods {
+			if strings.EqualFold(method, other) {
+				found = true
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Check if every value is required by the others as well, an empty value matches any value.
+func matchesAll(values, others map[string]string) bool {
+
+	for name, value := range values {
+		other, ok := others[name]
+
+		if !ok || (value != "" && value != other) {
+			return false
+		}
+	}
+
+	return true
+}
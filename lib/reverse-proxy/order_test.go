@@ -0,0 +1,68 @@
+package reverse
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortRoutesByLongestPrefix(t *testing.T) {
+	api := route("/api/")
+	admin := route("/api/admin/")
+	root := route("/")
+
+	assert.Equal(t, []*RouteConfig{admin, api, root}, sortRoutes([]*RouteConfig{root, api, admin}))
+	assert.Equal(t, []*RouteConfig{admin, api, root}, sortRoutes([]*RouteConfig{api, admin, root}))
+}
+
+func TestSortRoutesByPriority(t *testing.T) {
+	api := route("/api/")
+	api.Priority = 10
+	admin := route("/api/admin/")
+
+	assert.Equal(t, []*RouteConfig{api, admin}, sortRoutes([]*RouteConfig{admin, api}))
+}
+
+func TestSortRoutesByMatchers(t *testing.T) {
+	anyHost := route("/")
+	host := route("/")
+	host.Host = "api.localtest.me"
+
+	assert.Equal(t, []*RouteConfig{host, anyHost}, sortRoutes([]*RouteConfig{anyHost, host}))
+}
+
+func TestShadowedRoutes(t *testing.T) {
+	api := route("/api/")
+	admin := route("/api/admin/")
+
+	assert.True(t, api.shadows(admin))
+	assert.False(t, admin.shadows(api))
+
+	api.Methods = []string{"GET", "POST"}
+	admin.Methods = []string{"get"}
+
+	assert.True(t, api.shadows(admin))
+
+	admin.Methods = nil
+
+	assert.False(t, api.shadows(admin))
+
+	host := route("/")
+	host.Host = "api.localtest.me"
+	host.Headers = map[string]string{"X-Role": ""}
+	admin.Host = "api.localtest.me"
+	admin.Headers = map[string]string{"X-Role": "admin"}
+
+	assert.True(t, host.shadows(admin))
+
+	admin.Host = "web.localtest.me"
+
+	assert.False(t, host.shadows(admin))
+}
+
+func route(path string) *RouteConfig {
+	targetURL, _ := url.Parse("http://localhost:8080")
+
+	return NewRouteConfig(path, "", targetURL)
+}
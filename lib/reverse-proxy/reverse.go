@@ -5,10 +5,15 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/gorilla/mux"
 	"github.com/newestuser/eureka-proxy/lib/logging"
+	"github.com/newestuser/eureka-proxy/lib/reverse-proxy/header"
 	"github.com/newestuser/eureka-proxy/lib/reverse-proxy/strip"
+	"github.com/newestuser/eureka-proxy/lib/tlsutil"
 	"github.com/rs/cors"
 )
 
@@ -16,6 +21,9 @@ type Proxy interface {
 	Start() error
 
 	ServeHTTP(http.ResponseWriter, *http.Request)
+
+	// Replace the routes of the running proxy, the requests that are in progress complete with the old routes.
+	Reload(routes []*RouteConfig) error
 }
 
 func SingleRoute(route, strip string, target *url.URL) []*RouteConfig {
@@ -32,68 +40,242 @@ type ProxyConfig struct {
 	Trace      bool
 	LoggingOff bool
 	EnableCORS bool
+	// HTTPS is served when it is enabled, nil serves plain HTTP.
+	TLS *tlsutil.ServerConfig
+}
+
+// Create a route that proxies to the first reachable target, the targets are tried in the given order.
+func FailoverRoute(route, strip string, targets []*url.URL) []*RouteConfig {
+	c := NewRouteConfig(route, strip, targets[0])
+	c.FailoverURLs = targets[1:]
+
+	return []*RouteConfig{c}
+}
+
+// Create a route that spreads the requests across the targets with the balancing strategy, round-robin when it is empty.
+func BalancedRoute(route, strip, balancing string, targets []*Target) []*RouteConfig {
+	c := NewRouteConfig(route, strip, targets[0].URL)
+	c.Targets = targets
+	c.Balancing = balancing
+
+	return []*RouteConfig{c}
 }
 
 type RouteConfig struct {
 	Route     string
 	TargetURL *url.URL
 	PathStrip string
+	// The requests match the route only when they also match all the configured matchers, the matchers follow the mux templates.
+	// The host matches any port unless it has one, for example api.localtest.me or {app}.localtest.me:4400.
+	Host    string
+	Methods []string
+	// The values the headers and the query parameters must have, an empty value matches any value.
+	Headers map[string]string
+	Queries map[string]string
+	// The routes with a higher priority are matched first, the routes with the same priority are matched by the longest path prefix.
+	Priority int
+	// The rules that rewrite the path the PathStrip left, they are tried in order and the first matching rule rewrites the path.
+	PathRewrites []string
+	// The targets that are tried in order when the TargetURL cannot be reached.
+	FailoverURLs []*url.URL
+	// The targets the requests are spread across instead of the TargetURL, the TargetURL is the first of them.
+	Targets []*Target
+	// The strategy that picks the target of a request: round-robin, random, least-connections or weighted.
+	Balancing string
+	// The TLS settings used to connect to the targets, nil uses the defaults of the system.
+	TLS *tlsutil.ClientConfig
+	// The rules applied in order to the headers of the requests and of the responses.
+	RequestHeaders  []*header.Rule
+	ResponseHeaders []*header.Rule
+	// Send the host of the target in the Host header instead of the host the client requested.
+	RewriteHost bool
 }
 
 func (r RouteConfig) String() string {
-	if r.PathStrip == "" {
-		return fmt.Sprintf("Route(from:'%v' to:'%v')", r.Route, r.TargetURL)
-	}
+	target := fmt.Sprintf("%v", r.TargetURL)
 
-	return fmt.Sprintf("Route(from:'%v' to:'%v' strip:'%v')", r.Route, r.TargetURL, r.PathStrip)
-}
+	for _, failover := range r.FailoverURLs {
+		target = fmt.Sprintf("%s, %v", target, failover)
+	}
 
-func NewReverseProxy(conf *ProxyConfig) (Proxy, error) {
-	router := mux.NewRouter()
-	logger := logging.NewLevelLogger(conf.Trace, !conf.LoggingOff)
+	if len(r.Targets) > 0 {
+		target = ""
 
-	for _, route := range conf.Routes {
-		rHandler, err := reverseHandler(logger, route)
+		for i, t := range r.Targets {
+			if i > 0 {
+				target += ", "
+			}
 
-		if err != nil {
-			return nil, err
+			if t.Weight > 1 {
+				target = fmt.Sprintf("%s%v (weight %d)", target, t.URL, t.Weight)
+			} else {
+				target = fmt.Sprintf("%s%v", target, t.URL)
+			}
 		}
+	}
+
+	rules := ""
 
-		router.PathPrefix(route.Route).Handler(rHandler)
+	if r.Host != "" {
+		rules = fmt.Sprintf("%s host:'%v'", rules, r.Host)
 	}
 
-	var proxyHandler http.Handler = router
+	if len(r.Methods) > 0 {
+		rules = fmt.Sprintf("%s methods:'%v'", rules, strings.Join(r.Methods, ","))
+	}
 
-	if conf.EnableCORS {
-		proxyHandler = cors.AllowAll().Handler(proxyHandler)
+	for _, pair := range sortedPairs(r.Headers) {
+		rules = fmt.Sprintf("%s header:'%v'", rules, pair)
+	}
+
+	for _, pair := range sortedPairs(r.Queries) {
+		rules = fmt.Sprintf("%s query:'%v'", rules, pair)
+	}
+
+	if r.PathStrip != "" {
+		rules = fmt.Sprintf("%s strip:'%v'", rules, r.PathStrip)
+	}
+
+	for _, rewrite := range r.PathRewrites {
+		rules = fmt.Sprintf("%s rewrite:'%v'", rules, rewrite)
+	}
+
+	for _, rule := range r.RequestHeaders {
+		rules = fmt.Sprintf("%s request:'%v'", rules, rule)
+	}
+
+	for _, rule := range r.ResponseHeaders {
+		rules = fmt.Sprintf("%s response:'%v'", rules, rule)
 	}
 
-	return &reverseProxy{
-		router: proxyHandler,
+	if r.RewriteHost {
+		rules = fmt.Sprintf("%s rewriteHost", rules)
+	}
+
+	if r.Balancing != "" {
+		rules = fmt.Sprintf("%s balancing:'%v'", rules, r.Balancing)
+	}
+
+	if r.Priority != 0 {
+		rules = fmt.Sprintf("%s priority:%d", rules, r.Priority)
+	}
+
+	return fmt.Sprintf("Route(from:'%v' to:'%v'%s)", r.Route, target, rules)
+}
+
+func NewReverseProxy(conf *ProxyConfig) (Proxy, error) {
+	logger := logging.NewLevelLogger(conf.Trace, !conf.LoggingOff)
+
+	proxy := &reverseProxy{
 		logger: logger,
 		conf:   conf,
-	}, nil
+	}
+
+	router, err := proxy.newRouter(sortRoutes(conf.Routes))
+
+	if err != nil {
+		return nil, err
+	}
+
+	proxy.router = router
+
+	return proxy, nil
 }
 
 type reverseProxy struct {
+	mu     sync.RWMutex
 	router http.Handler
 	logger logging.Logger
 	conf   *ProxyConfig
 }
 
+func (proxy *reverseProxy) newRouter(routes []*RouteConfig) (http.Handler, error) {
+	router := mux.NewRouter()
+
+	for i, route := range routes {
+		for _, previous := range routes[:i] {
+			if previous.shadows(route) {
+				proxy.logger.ErrF("The route %s is shadowed by %s and never receives requests\n", route.String(), previous.String())
+				break
+			}
+		}
+
+		rHandler, err := reverseHandler(proxy.logger, route)
+
+		if err != nil {
+			return nil, err
+		}
+
+		muxRoute := router.PathPrefix(route.Route)
+
+		if route.Host != "" {
+			muxRoute = muxRoute.Host(route.Host)
+		}
+
+		if len(route.Methods) > 0 {
+			muxRoute = muxRoute.Methods(route.Methods...)
+		}
+
+		for name, value := range route.Headers {
+			muxRoute = muxRoute.Headers(name, value)
+		}
+
+		for name, value := range route.Queries {
+			muxRoute = muxRoute.Queries(name, value)
+		}
+
+		if err := muxRoute.GetError(); err != nil {
+			return nil, fmt.Errorf("the matchers of %s are invalid err: %s", route.String(), err.Error())
+		}
+
+		muxRoute.Handler(rHandler)
+	}
+
+	var proxyHandler http.Handler = router
+
+	if proxy.conf.EnableCORS {
+		proxyHandler = cors.AllowAll().Handler(proxyHandler)
+	}
+
+	return proxyHandler, nil
+}
+
 func (proxy *reverseProxy) Start() error {
 
-	proxy.logger.InfoF("Reverse proxy starting on port %d\n", proxy.conf.Port)
+	proxy.logger.InfoF("Reverse proxy starting on %s://localhost:%d\n", proxy.conf.TLS.Scheme(), proxy.conf.Port)
 
-	for _, r := range proxy.conf.Routes {
+	for _, r := range sortRoutes(proxy.conf.Routes) {
 		proxy.logger.InfoF("Proxying to %s\n", r.String())
 	}
 
-	return http.ListenAndServe(fmt.Sprintf(":%d", proxy.conf.Port), proxy.router)
+	return tlsutil.ListenAndServe(fmt.Sprintf(":%d", proxy.conf.Port), proxy, proxy.conf.TLS)
 }
 
 func (proxy *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	proxy.router.ServeHTTP(w, r)
+	proxy.mu.RLock()
+	router := proxy.router
+	proxy.mu.RUnlock()
+
+	router.ServeHTTP(w, r)
+}
+
+func (proxy *reverseProxy) Reload(routes []*RouteConfig) error {
+	routes = sortRoutes(routes)
+	router, err := proxy.newRouter(routes)
+
+	if err != nil {
+		return err
+	}
+
+	proxy.mu.Lock()
+	proxy.router = router
+	proxy.mu.Unlock()
+
+	for _, r := range routes {
+		proxy.logger.InfoF("Proxying to %s\n", r.String())
+	}
+
+	return nil
 }
 
 func reverseHandler(logger logging.Logger, c *RouteConfig) (http.Handler, error) {
@@ -104,9 +286,64 @@ func reverseHandler(logger logging.Logger, c *RouteConfig) (http.Handler, error)
 		return nil, err
 	}
 
-	reverseHandler := httputil.NewSingleHostReverseProxy(c.TargetURL)
+	rewrites, err := strip.New(c.PathRewrites...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := c.TLS.Transport()
+
+	if err != nil {
+		return nil, fmt.Errorf("the TLS settings of %s are invalid err: %s", c.String(), err.Error())
+	}
+
+	var reverseHandler http.Handler = newSingleHostProxy(c.TargetURL, transport, c.RewriteHost)
+
+	if len(c.FailoverURLs) > 0 {
+		reverseHandler = newFailoverHandler(logger, append([]*url.URL{c.TargetURL}, c.FailoverURLs...), transport, c.RewriteHost)
+	}
+
+	if len(c.Targets) > 0 {
+		if reverseHandler, err = newBalancerHandler(logger, c.Balancing, c.Targets, transport, c.RewriteHost); err != nil {
+			return nil, err
+		}
+	}
+
 	logHandler := logging.NewHandler(logger, reverseHandler)
-	stripHandler := strip.NewHandler(s, logHandler)
+	headerHandler := header.NewHandler(c.RequestHeaders, c.ResponseHeaders, logHandler)
+	stripHandler := strip.NewHandler(s, strip.NewHandler(rewrites, headerHandler))
 
 	return stripHandler, err
 }
+
+// Create the proxy of a target, by default the Host header the client requested is kept.
+func newSingleHostProxy(target *url.URL, transport http.RoundTripper, rewriteHost bool) *httputil.ReverseProxy {
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = transport
+
+	if rewriteHost {
+		director := proxy.Director
+
+		proxy.Director = func(r *http.Request) {
+			director(r)
+			r.Host = target.Host
+		}
+	}
+
+	return proxy
+}
+
+func sortedPairs(values map[string]string) []string {
+
+	pairs := make([]string, 0, len(values))
+
+	for name, value := range values {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", name, value))
+	}
+
+	sort.Strings(pairs)
+
+	return pairs
+}
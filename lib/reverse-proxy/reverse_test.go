@@ -0,0 +1,223 @@
+package reverse
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newestuser/eureka-proxy/lib/reload"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteMatchers(t *testing.T) {
+	api, apiURL := target("api")
+	defer api.Close()
+	web, webURL := target("web")
+	defer web.Close()
+	admin, adminURL := target("admin")
+	defer admin.Close()
+	debug, debugURL := target("debug")
+	defer debug.Close()
+
+	withHeader := NewRouteConfig("/", "", adminURL)
+	withHeader.Host = "api.localtest.me"
+	withHeader.Headers = map[string]string{"X-Role": "admin"}
+
+	withQuery := NewRouteConfig("/", "", debugURL)
+	withQuery.Host = "api.localtest.me"
+	withQuery.Methods = []string{"GET"}
+	withQuery.Queries = map[string]string{"debug": ""}
+
+	apiRoute := NewRouteConfig("/", "", apiURL)
+	apiRoute.Host = "api.localtest.me"
+
+	webRoute := NewRouteConfig("/", "", webURL)
+	webRoute.Host = "{app}.localtest.me"
+
+	proxy, err := NewReverseProxy(&ProxyConfig{Routes: []*RouteConfig{withHeader, withQuery, apiRoute, webRoute}, LoggingOff: true})
+	assert.Nil(t, err)
+
+	assert.Equal(t, "api", send(proxy, http.MethodGet, "http://api.localtest.me:4400/foo", nil))
+	assert.Equal(t, "web", send(proxy, http.MethodGet, "http://web.localtest.me:4400/foo", nil))
+	assert.Equal(t, "admin", send(proxy, http.MethodGet, "http://api.localtest.me/foo", map[string]string{"X-Role": "admin"}))
+	assert.Equal(t, "debug", send(proxy, http.MethodGet, "http://api.localtest.me/foo?debug=1", nil))
+	assert.Equal(t, "api", send(proxy, http.MethodPost, "http://api.localtest.me/foo?debug=1", nil))
+	assert.Equal(t, "404", send(proxy, http.MethodGet, "http://localhost/foo", nil))
+}
+
+func TestInvalidRouteMatchers(t *testing.T) {
+	api, apiURL := target("api")
+	defer api.Close()
+
+	route := NewRouteConfig("/", "", apiURL)
+	route.Host = "{app"
+
+	_, err := NewReverseProxy(&ProxyConfig{Routes: []*RouteConfig{route}, LoggingOff: true})
+
+	assert.NotNil(t, err)
+}
+
+func TestStripAndRewrite(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	targetURL, _ := url.Parse(server.URL)
+
+	route := NewRouteConfig("/api/", "/api/:", targetURL)
+	route.PathRewrites = []string{"prefix:v1/=>legacy/", `regex:^v(\d+)/=>v$1/current/`}
+
+	proxy, err := NewReverseProxy(&ProxyConfig{Routes: []*RouteConfig{route}, LoggingOff: true})
+	assert.Nil(t, err)
+
+	assert.Equal(t, "/legacy/foo", send(proxy, http.MethodGet, "http://localhost/api/v1/foo", nil))
+	assert.Equal(t, "/v2/current/foo", send(proxy, http.MethodGet, "http://localhost/api/v2/foo", nil))
+	assert.Equal(t, "/bar", send(proxy, http.MethodGet, "http://localhost/api/bar", nil))
+}
+
+func TestReloadFromConfigFile(t *testing.T) {
+	api, apiURL := target("api")
+	defer api.Close()
+	web, webURL := target("web")
+	defer web.Close()
+
+	dir, err := ioutil.TempDir("", "routes")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "routes")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("/api/ "+apiURL.String()), 0644))
+
+	proxy, err := NewReverseProxy(&ProxyConfig{Routes: SingleRoute("/api/", "", apiURL), LoggingOff: true})
+	assert.Nil(t, err)
+
+	reload.Watch(path, 10*time.Millisecond, func(bytes []byte) error {
+		return proxy.Reload(parseTestRoutes(bytes))
+	})
+
+	rewrite(t, path, "/api/ "+apiURL.String()+"\n/web/ "+webURL.String())
+	assert.Equal(t, "web", awaitResponse(proxy, "http://localhost/web/foo", "web"))
+	assert.Equal(t, "api", send(proxy, http.MethodGet, "http://localhost/api/foo", nil))
+
+	// the routes with invalid matchers are rejected and the previous routes are still served
+	rewrite(t, path, "/web/ "+webURL.String()+" {app")
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, "web", send(proxy, http.MethodGet, "http://localhost/web/foo", nil))
+	assert.Equal(t, "api", send(proxy, http.MethodGet, "http://localhost/api/foo", nil))
+
+	rewrite(t, path, "/web/ "+webURL.String())
+	assert.Equal(t, "404", awaitResponse(proxy, "http://localhost/api/foo", "404"))
+	assert.Equal(t, "web", send(proxy, http.MethodGet, "http://localhost/web/foo", nil))
+}
+
+func TestReloadKeepsRoutesOnError(t *testing.T) {
+	api, apiURL := target("api")
+	defer api.Close()
+	web, webURL := target("web")
+	defer web.Close()
+
+	proxy, err := NewReverseProxy(&ProxyConfig{Routes: SingleRoute("/", "", apiURL), LoggingOff: true})
+	assert.Nil(t, err)
+
+	invalid := NewRouteConfig("/", "", webURL)
+	invalid.PathRewrites = []string{"regex:([=>/"}
+
+	assert.NotNil(t, proxy.Reload([]*RouteConfig{invalid}))
+	assert.Equal(t, "api", send(proxy, http.MethodGet, "http://localhost/foo", nil))
+
+	assert.Nil(t, proxy.Reload(SingleRoute("/", "", webURL)))
+	assert.Equal(t, "web", send(proxy, http.MethodGet, "http://localhost/foo", nil))
+}
+
+func TestRouteString(t *testing.T) {
+	targetURL, _ := url.Parse("http://localhost:8080")
+
+	route := NewRouteConfig("/api/", "/api/:", targetURL)
+	route.Host = "api.localtest.me"
+	route.Methods = []string{"GET", "POST"}
+	route.Headers = map[string]string{"X-Role": "admin"}
+	route.Queries = map[string]string{"debug": ""}
+	route.PathRewrites = []string{"prefix:v1/=>legacy/"}
+
+	assert.Equal(t, "Route(from:'/api/' to:'http://localhost:8080' host:'api.localtest.me' methods:'GET,POST' header:'X-Role=admin' query:'debug=' strip:'/api/:' rewrite:'prefix:v1/=>legacy/')", route.String())
+	assert.Equal(t, "Route(from:'/' to:'http://localhost:8080')", NewRouteConfig("/", "", targetURL).String())
+}
+
+// A target that responds with its name.
+func target(name string) (*httptest.Server, *url.URL) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	}))
+
+	targetURL, _ := url.Parse(server.URL)
+
+	return server, targetURL
+}
+
+// Routes written one per line as: path target [host].
+func parseTestRoutes(bytes []byte) []*RouteConfig {
+	var routes []*RouteConfig
+
+	for _, line := range strings.Split(string(bytes), "\n") {
+		fields := strings.Fields(line)
+		targetURL, _ := url.Parse(fields[1])
+
+		route := NewRouteConfig(fields[0], "", targetURL)
+
+		if len(fields) > 2 {
+			route.Host = fields[2]
+		}
+
+		routes = append(routes, route)
+	}
+
+	return routes
+}
+
+// Send requests until the proxy responds as expected, return the last response.
+func awaitResponse(proxy Proxy, target, expected string) string {
+	response := send(proxy, http.MethodGet, target, nil)
+
+	for i := 0; i < 100 && response != expected; i++ {
+		time.Sleep(10 * time.Millisecond)
+		response = send(proxy, http.MethodGet, target, nil)
+	}
+
+	return response
+}
+
+// Replace the file at once like the editors do, a file that is written in place can be read while it is still empty.
+func rewrite(t *testing.T, path, content string) {
+	tmp := path + ".tmp"
+
+	assert.Nil(t, ioutil.WriteFile(tmp, []byte(content), 0644))
+	assert.Nil(t, os.Rename(tmp, path))
+}
+
+func send(proxy Proxy, method, target string, headers map[string]string) string {
+	r := httptest.NewRequest(method, target, nil)
+
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusOK {
+		return strconv.Itoa(rec.Code)
+	}
+
+	body, _ := ioutil.ReadAll(rec.Body)
+
+	return string(body)
+}
@@ -3,22 +3,58 @@ package strip
 import (
 	"fmt"
 	"net/http"
+	"regexp"
 	"strings"
 )
 
+// The separator of the pattern and the replacement in the regex and prefix rules.
+const ruleSeparator = "=>"
+
 type String interface {
 	apply(path string) string
 }
 
-func New(pattern string) (String, error) {
+// A rule that rewrites the path, it reports if the path matched.
+type rule interface {
+	rewrite(path string) (string, bool)
+}
 
-	find, replace, err := resolveStrip(pattern)
+// Create the strip from the rules that are tried in order, the first rule that matches the path rewrites it.
+// 'foo:bar' replaces the first occurrence of foo with bar, 'prefix:/foo/=>/bar/' replaces the /foo/ prefix with /bar/
+// and 'regex:^/api/v(\d+)/(.*)=>/v$1/$2' replaces the first match of the regex where $1 refers to the first group.
+// Empty rules are skipped.
+func New(patterns ...string) (String, error) {
 
-	if err != nil {
-		return nil, err
+	rules := make([]rule, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+
+		r, err := resolveRule(pattern)
+
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, r)
 	}
 
-	return &stringStrip{find: find, replace: replace}, nil
+	return ruleStrip(rules), nil
+}
+
+type ruleStrip []rule
+
+func (s ruleStrip) apply(path string) string {
+
+	for _, r := range s {
+		if rewritten, ok := r.rewrite(path); ok {
+			return rewritten
+		}
+	}
+
+	return path
 }
 
 type stringStrip struct {
@@ -26,19 +62,84 @@ type stringStrip struct {
 	replace string
 }
 
-func (s *stringStrip) apply(path string) string {
+func (s *stringStrip) rewrite(path string) (string, bool) {
+
+	if !strings.Contains(path, s.find) {
+		return path, false
+	}
 
-	return strings.Replace(path, s.find, s.replace, 1)
+	return strings.Replace(path, s.find, s.replace, 1), true
 }
 
-func resolveStrip(strip string) (string, string, error) {
+type prefixStrip struct {
+	prefix  string
+	replace string
+}
+
+func (s *prefixStrip) rewrite(path string) (string, bool) {
+
+	if !strings.HasPrefix(path, s.prefix) {
+		return path, false
+	}
+
+	return s.replace + strings.TrimPrefix(path, s.prefix), true
+}
+
+type regexStrip struct {
+	regex   *regexp.Regexp
+	replace string
+}
+
+func (s *regexStrip) rewrite(path string) (string, bool) {
+
+	match := s.regex.FindStringSubmatchIndex(path)
 
-	if strip == "" {
-		return "", "", nil
+	if match == nil {
+		return path, false
 	}
 
+	replaced := s.regex.ExpandString(nil, s.replace, path, match)
+
+	return path[:match[0]] + string(replaced) + path[match[1]:], true
+}
+
+func resolveRule(pattern string) (rule, error) {
+
+	kind := strings.SplitN(pattern, ":", 2)[0]
+
+	if (kind == "prefix" || kind == "regex") && strings.Contains(pattern, ruleSeparator) {
+		parts := strings.SplitN(strings.TrimPrefix(pattern, kind+":"), ruleSeparator, 2)
+
+		if kind == "prefix" {
+			if parts[0] == "" {
+				return nil, fmt.Errorf("incorrect prefix rule: '%v' the prefix is empty", pattern)
+			}
+
+			return &prefixStrip{prefix: parts[0], replace: parts[1]}, nil
+		}
+
+		regex, err := regexp.Compile(parts[0])
+
+		if err != nil {
+			return nil, fmt.Errorf("incorrect regex rule: '%v' err: %s", pattern, err.Error())
+		}
+
+		return &regexStrip{regex: regex, replace: parts[1]}, nil
+	}
+
+	find, replace, err := resolveStrip(pattern)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &stringStrip{find: find, replace: replace}, nil
+}
+
+func resolveStrip(strip string) (string, string, error) {
+
 	if !strings.Contains(strip, ":") {
-		return "", "", fmt.Errorf("incorrect strip format: '%v' example 'foo:bar'", strip)
+		return "", "", fmt.Errorf("incorrect strip format: '%v' example 'foo:bar', 'prefix:/foo/=>/bar/' or 'regex:^/foo/(.*)=>/bar/$1'", strip)
 	}
 
 	parts := strings.Split(strip, ":")
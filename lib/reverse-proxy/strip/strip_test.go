@@ -42,3 +42,50 @@ func TestErrorForWrongFormat(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Nil(t, stringStrip)
 }
+
+func TestRegexWithCaptureGroups(t *testing.T) {
+	stringStrip, err := New(`regex:^/api/v(\d+)/(.*)=>/v$1/$2`)
+
+	assert.Nil(t, err)
+
+	assert.Equal(t, "/v2/foo/bar", stringStrip.apply("/api/v2/foo/bar"))
+	assert.Equal(t, "/service/api/v2/foo", stringStrip.apply("/service/api/v2/foo"))
+}
+
+func TestRegexWithColon(t *testing.T) {
+	stringStrip, err := New(`regex:/foo:(\w+)=>/foo/$1`)
+
+	assert.Nil(t, err)
+
+	assert.Equal(t, "/api/foo/bar", stringStrip.apply("/api/foo:bar"))
+}
+
+func TestPrefixIsAnchored(t *testing.T) {
+	stringStrip, err := New("prefix:/foo/=>/bar/")
+
+	assert.Nil(t, err)
+
+	assert.Equal(t, "/bar/foo/baz", stringStrip.apply("/foo/foo/baz"))
+	assert.Equal(t, "/api/foo/baz", stringStrip.apply("/api/foo/baz"))
+}
+
+func TestFirstMatchingRuleRewrites(t *testing.T) {
+	stringStrip, err := New("prefix:/foo/v1/=>/legacy/", `regex:^/foo/v(\d+)/=>/v$1/`, "", "foo:bar")
+
+	assert.Nil(t, err)
+
+	assert.Equal(t, "/legacy/baz", stringStrip.apply("/foo/v1/baz"))
+	assert.Equal(t, "/v2/baz", stringStrip.apply("/foo/v2/baz"))
+	assert.Equal(t, "/api/bar", stringStrip.apply("/api/foo"))
+	assert.Equal(t, "/api/baz", stringStrip.apply("/api/baz"))
+}
+
+func TestErrorForInvalidRules(t *testing.T) {
+	_, err := New("regex:^/foo/(=>/bar")
+
+	assert.NotNil(t, err)
+
+	_, err = New("prefix:=>/bar")
+
+	assert.NotNil(t, err)
+}
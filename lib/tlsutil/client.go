@@ -0,0 +1,95 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// The TLS settings used to connect to an upstream, nil uses the defaults of the system.
+type ClientConfig struct {
+	// A bundle of the certificate authorities that are trusted instead of the ones of the system.
+	CAFile string
+	// The client certificate and its key, required by the upstreams that verify the clients.
+	CertFile string
+	KeyFile  string
+	// The name that is sent and verified instead of the host of the upstream url.
+	ServerName string
+	// Accept any certificate of the upstream, only meant for local development.
+	InsecureSkipVerify bool
+}
+
+// Create the transport that connects to the upstream with the TLS settings.
+func (c *ClientConfig) Transport() (*http.Transport, error) {
+
+	if c == nil {
+		return http.DefaultTransport.(*http.Transport), nil
+	}
+
+	tlsConf, err := c.TLSConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConf
+
+	return transport, nil
+}
+
+// Create the http client that connects to the upstream with the TLS settings.
+func (c *ClientConfig) Client() (*http.Client, error) {
+
+	if c == nil {
+		return http.DefaultClient, nil
+	}
+
+	transport, err := c.Transport()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// Create the TLS configuration, the certificate files are read upfront so that missing files are reported right away.
+func (c *ClientConfig) TLSConfig() (*tls.Config, error) {
+
+	tlsConf := &tls.Config{ServerName: c.ServerName, InsecureSkipVerify: c.InsecureSkipVerify}
+
+	if c.CAFile != "" {
+		bundle, err := ioutil.ReadFile(c.CAFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not read the certificate authorities err: %s", err.Error())
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificate authorities were found in %s", c.CAFile)
+		}
+
+		tlsConf.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, fmt.Errorf("both the client certificate and the key are required")
+		}
+
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not load the client certificate err: %s", err.Error())
+		}
+
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConf, nil
+}
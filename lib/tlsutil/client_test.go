@@ -0,0 +1,56 @@
+package tlsutil
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientTrustsCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "tlsutil")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	assert.Nil(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644))
+
+	assert.NotNil(t, get(t, nil, server.URL))
+	assert.Nil(t, get(t, &ClientConfig{CAFile: caFile}, server.URL))
+	assert.Nil(t, get(t, &ClientConfig{CAFile: caFile, ServerName: "example.com"}, server.URL))
+	assert.NotNil(t, get(t, &ClientConfig{CAFile: caFile, ServerName: "bar-service.net"}, server.URL))
+	assert.Nil(t, get(t, &ClientConfig{InsecureSkipVerify: true}, server.URL))
+}
+
+func TestClientRequiresKey(t *testing.T) {
+	_, err := (&ClientConfig{CertFile: "client.crt"}).Transport()
+
+	assert.NotNil(t, err)
+}
+
+func TestClientWithoutCertificateAuthorities(t *testing.T) {
+	_, err := (&ClientConfig{CAFile: "missing.pem"}).Transport()
+
+	assert.NotNil(t, err)
+}
+
+func get(t *testing.T, c *ClientConfig, url string) error {
+	client, err := c.Client()
+	assert.Nil(t, err)
+
+	resp, err := client.Get(url)
+
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
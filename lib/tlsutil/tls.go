@@ -0,0 +1,201 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The names of the self-signed certificate files in the certificate directory.
+const (
+	selfSignedCert = "localhost.crt"
+	selfSignedKey  = "localhost.key"
+)
+
+// The TLS settings of a listener, plain HTTP is served when neither a certificate nor a self-signed one is configured.
+type ServerConfig struct {
+	CertFile string
+	KeyFile  string
+	// Serve a self-signed certificate for localhost when no certificate is configured.
+	// The certificate is generated on the first run and reused afterwards so that it only has to be trusted once.
+	SelfSigned bool
+}
+
+// Check if the listener serves HTTPS.
+func (c *ServerConfig) IsEnabled() bool {
+	return c != nil && (c.CertFile != "" || c.KeyFile != "" || c.SelfSigned)
+}
+
+// The scheme the listener is serving.
+func (c *ServerConfig) Scheme() string {
+	if c.IsEnabled() {
+		return "https"
+	}
+
+	return "http"
+}
+
+// Listen on the address and serve HTTPS when it is enabled in the configuration, plain HTTP otherwise.
+func ListenAndServe(addr string, handler http.Handler, c *ServerConfig) error {
+
+	if !c.IsEnabled() {
+		return http.ListenAndServe(addr, handler)
+	}
+
+	certFile, keyFile, err := c.certificate()
+
+	if err != nil {
+		return err
+	}
+
+	return http.ListenAndServeTLS(addr, certFile, keyFile, handler)
+}
+
+// Resolve the files of the certificate, the self-signed certificate is generated when it is missing.
+func (c *ServerConfig) certificate() (string, string, error) {
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return "", "", fmt.Errorf("both the certificate and the key are required to serve HTTPS")
+		}
+
+		return c.CertFile, c.KeyFile, nil
+	}
+
+	dir, err := CertDir()
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return SelfSignedCertificate(dir)
+}
+
+// The directory where the self-signed certificate is kept, it is shared by all the proxies.
+func CertDir() (string, error) {
+
+	configDir, err := os.UserConfigDir()
+
+	if err != nil {
+		return "", fmt.Errorf("could not resolve the directory of the self-signed certificate err: %s", err.Error())
+	}
+
+	return filepath.Join(configDir, "eureka-proxy"), nil
+}
+
+// Return the files of the self-signed certificate for localhost in the directory, the certificate is generated
+// when it is missing, it expired or it is a CA certificate generated by an older version.
+func SelfSignedCertificate(dir string) (string, string, error) {
+
+	certFile := filepath.Join(dir, selfSignedCert)
+	keyFile := filepath.Join(dir, selfSignedKey)
+
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && !needsRenewal(certFile) {
+		return certFile, keyFile, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", fmt.Errorf("could not create the directory of the self-signed certificate err: %s", err.Error())
+	}
+
+	certBytes, keyBytes, err := generateSelfSigned(time.Now())
+
+	if err != nil {
+		return "", "", fmt.Errorf("could not generate the self-signed certificate err: %s", err.Error())
+	}
+
+	if err := ioutil.WriteFile(keyFile, keyBytes, 0600); err != nil {
+		return "", "", fmt.Errorf("could not save the self-signed certificate err: %s", err.Error())
+	}
+
+	if err := ioutil.WriteFile(certFile, certBytes, 0644); err != nil {
+		return "", "", fmt.Errorf("could not save the self-signed certificate err: %s", err.Error())
+	}
+
+	log.Printf("A self-signed certificate for localhost was generated in %s, trust %s to avoid the browser warnings\n", dir, certFile)
+
+	return certFile, keyFile, nil
+}
+
+// Generate a certificate for localhost and its key in PEM format, the certificate is valid for a year.
+func generateSelfSigned(now time.Time) ([]byte, []byte, error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// browsers reject CA certificates that are served by a server, the certificate is a leaf
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "localhost", Organization: []string{"eureka-proxy"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyBytes := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	return certBytes, keyBytes, nil
+}
+
+func needsRenewal(certFile string) bool {
+
+	bytes, err := ioutil.ReadFile(certFile)
+
+	if err != nil {
+		return true
+	}
+
+	block, _ := pem.Decode(bytes)
+
+	if block == nil {
+		return true
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+
+	if err != nil {
+		return true
+	}
+
+	return time.Now().After(cert.NotAfter) || cert.IsCA
+}
@@ -0,0 +1,108 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelfSignedCertificateIsReused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsutil")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile, err := SelfSignedCertificate(dir)
+	assert.Nil(t, err)
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	assert.Nil(t, err)
+	assert.NotNil(t, cert.Certificate)
+
+	first, _ := ioutil.ReadFile(certFile)
+
+	_, _, err = SelfSignedCertificate(dir)
+	assert.Nil(t, err)
+
+	second, _ := ioutil.ReadFile(certFile)
+	assert.Equal(t, first, second)
+}
+
+func TestCertificateRequiresKey(t *testing.T) {
+	c := &ServerConfig{CertFile: "localhost.crt"}
+
+	_, _, err := c.certificate()
+
+	assert.NotNil(t, err)
+	assert.True(t, c.IsEnabled())
+	assert.Equal(t, "https", c.Scheme())
+}
+
+func TestPlainHttpWithoutCertificate(t *testing.T) {
+	var c *ServerConfig
+
+	assert.False(t, c.IsEnabled())
+	assert.Equal(t, "http", (&ServerConfig{}).Scheme())
+}
+
+func TestSelfSignedCertificateIsLeaf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsutil")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, _, err := SelfSignedCertificate(dir)
+	assert.Nil(t, err)
+
+	cert := parseCertificate(t, certFile)
+
+	assert.False(t, cert.IsCA)
+	assert.Equal(t, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment, cert.KeyUsage)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, cert.ExtKeyUsage)
+	assert.Nil(t, cert.VerifyHostname("localhost"))
+}
+
+func TestSelfSignedCACertificateIsReplaced(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsutil")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now(), NotAfter: time.Now().AddDate(1, 0, 0), IsCA: true, BasicConstraintsValid: true, DNSNames: []string{"localhost"}}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, selfSignedCert), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, selfSignedKey), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	certFile, _, err := SelfSignedCertificate(dir)
+	assert.Nil(t, err)
+
+	assert.False(t, parseCertificate(t, certFile).IsCA)
+}
+
+func parseCertificate(t *testing.T, certFile string) *x509.Certificate {
+	bytes, err := ioutil.ReadFile(certFile)
+	assert.Nil(t, err)
+
+	block, _ := pem.Decode(bytes)
+	assert.NotNil(t, block)
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	assert.Nil(t, err)
+
+	return cert
+}